@@ -0,0 +1,213 @@
+package commander
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	MAX_ALIAS_DEPTH = 16
+)
+
+// SetAlias defines an alias, such that name may be used in place of value as the first word of any command.  any
+// arguments following the alias are appended to value.  if an AliasFile is configured, the aliases are saved to it.
+func (c *Commander) SetAlias(name string, value string) error {
+	if !isAliasName(name) {
+		return fmt.Errorf("invalid alias name \"%s\"", name)
+	}
+
+	c.aliasLock.Lock()
+	c.aliases[name] = value
+	c.aliasLock.Unlock()
+
+	return c.saveAliases()
+}
+
+// RemoveAlias removes an alias, returning an error if it isn't defined
+func (c *Commander) RemoveAlias(name string) error {
+	c.aliasLock.Lock()
+	_, exists := c.aliases[name]
+	delete(c.aliases, name)
+	c.aliasLock.Unlock()
+
+	if !exists {
+		return fmt.Errorf("alias \"%s\" is not defined", name)
+	}
+
+	return c.saveAliases()
+}
+
+// Aliases returns a copy of all defined aliases
+func (c *Commander) Aliases() map[string]string {
+	c.aliasLock.RLock()
+	defer c.aliasLock.RUnlock()
+
+	aliases := map[string]string{}
+	for name, value := range c.aliases {
+		aliases[name] = value
+	}
+
+	return aliases
+}
+
+// aliasNames returns the names of all defined aliases in sorted order
+func (c *Commander) aliasNames() []string {
+	c.aliasLock.RLock()
+	defer c.aliasLock.RUnlock()
+
+	names := []string{}
+	for name := range c.aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// GetAlias returns the value of an alias
+func (c *Commander) GetAlias(name string) (string, bool) {
+	c.aliasLock.RLock()
+	defer c.aliasLock.RUnlock()
+
+	value, ok := c.aliases[name]
+	return value, ok
+}
+
+// expandAliases replaces the first word of each command in the line with the value of the alias it names
+func (c *Commander) expandAliases(line string) string {
+	return c.expandAliasesWithin(line, map[string]struct{}{})
+}
+
+// expandAliasesWithin performs alias expansion on the line.  the value of an alias is itself expanded, except for
+// any aliases already being expanded (present in seen), which prevents an alias from recursively expanding itself.
+func (c *Commander) expandAliasesWithin(line string, seen map[string]struct{}) string {
+	if len(seen) >= MAX_ALIAS_DEPTH {
+		return line
+	}
+
+	// a line containing a syntax error is expanded as far as possible, leaving the error to be reported on execution
+	tokenGroups, _ := Tokenize(line)
+	// replace from the end of the line, so that the offsets of earlier groups remain valid
+	for i := len(tokenGroups) - 1; i >= 0; i-- {
+		tokenGroup := tokenGroups[i]
+		if tokenGroup.IsRedirect() || len(tokenGroup.Tokens) == 0 {
+			continue
+		}
+
+		name := tokenGroup.Tokens[0]
+		if _, exists := seen[name]; exists {
+			continue
+		}
+
+		value, ok := c.GetAlias(name)
+		if !ok {
+			continue
+		}
+
+		// only an unquoted word is subject to alias expansion
+		word := tokenGroup.Words[0]
+		if word.Raw != name {
+			continue
+		}
+		start := word.Offset
+
+		innerSeen := map[string]struct{}{name: {}}
+		for k := range seen {
+			innerSeen[k] = struct{}{}
+		}
+		line = line[:start] + c.expandAliasesWithin(value, innerSeen) + line[start+len(name):]
+	}
+
+	return line
+}
+
+// expandArgAlias replaces the first argument with the value of the alias it names, for use in non-interactive
+// execution where the arguments have already been tokenized
+func (c *Commander) expandArgAlias(args []string) ([]string, error) {
+	expanded := map[string]struct{}{}
+	for depth := 0; depth < MAX_ALIAS_DEPTH && len(args) > 0; depth++ {
+		if _, exists := expanded[args[0]]; exists {
+			break
+		}
+
+		value, ok := c.GetAlias(args[0])
+		if !ok {
+			break
+		}
+
+		tokenGroups, err := Tokenize(value)
+		if err != nil {
+			return nil, fmt.Errorf("alias \"%s\" is invalid: %w", args[0], err)
+		}
+		if len(tokenGroups) != 1 {
+			return nil, fmt.Errorf("alias \"%s\" contains control operators, and can only be used within the shell", args[0])
+		}
+
+		expanded[args[0]] = struct{}{}
+		args = append(tokenGroups[0].Tokens, args[1:]...)
+	}
+
+	return args, nil
+}
+
+// loadAliases reads the aliases saved to the AliasFile, if there is one
+func (c *Commander) loadAliases() error {
+	if c.Config.AliasFile == "" {
+		return nil
+	}
+
+	f, err := os.Open(c.Config.AliasFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	c.aliasLock.Lock()
+	defer c.aliasLock.Unlock()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), "=")
+		if ok && isAliasName(name) {
+			c.aliases[name] = value
+		}
+	}
+
+	return scanner.Err()
+}
+
+// saveAliases writes all aliases to the AliasFile, if there is one
+func (c *Commander) saveAliases() error {
+	if c.Config.AliasFile == "" {
+		return nil
+	}
+
+	aliases := c.Aliases()
+	lines := []string{}
+	for _, name := range c.aliasNames() {
+		lines = append(lines, fmt.Sprintf("%s=%s\n", name, aliases[name]))
+	}
+
+	err := os.WriteFile(c.Config.AliasFile, []byte(strings.Join(lines, "")), 0644)
+	if err != nil {
+		return fmt.Errorf("unable to save aliases: %w", err)
+	}
+
+	return nil
+}
+
+// isAliasName returns true if the name could be typed as an unquoted word
+func isAliasName(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+
+	return !strings.ContainsAny(name, " \t'\"$=|&;<>")
+}
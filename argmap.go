@@ -1,87 +1,257 @@
 package commander
 
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ArgMap maps the names (and short names) of a command's flags and arguments to their values.  flags which were not
+// given on the command line hold their DefaultValue, and those without a default hold nil.  multiple values are held
+// as a []any.
 type ArgMap map[string]any
 
-func (m ArgMap) GetString(argName string) string {
-	v := m[argName]
-	if v == nil {
-		return ""
+// Get returns the named value as a T.  a value which is missing or nil yields the zero value of T, while a value of a
+// different type yields an error.  values are converted between types of the same kind, such that a named string type
+// may be retrieved from a STRING flag, and an int64 from an INT flag.
+func Get[T any](m ArgMap, name string) (T, error) {
+	var zero T
+	value, ok := m.Lookup(name)
+	if !ok {
+		return zero, nil
 	}
 
-	return v.(string)
+	return convertValue[T](name, value)
 }
 
-func (m ArgMap) GetInt(argName string) int {
-	v := m[argName]
-	if v == nil {
-		return 0
+// GetArray returns the named multiple values (see AllowMultiple) as a []T, converting each as Get does.  a missing
+// value yields an empty slice, and a single value yields a slice holding only that value.
+func GetArray[T any](m ArgMap, name string) ([]T, error) {
+	value, ok := m.Lookup(name)
+	if !ok {
+		return []T{}, nil
 	}
 
-	return v.(int)
+	values, ok := value.([]any)
+	if !ok {
+		values = []any{value}
+	}
+
+	arr := make([]T, len(values))
+	for idx, item := range values {
+		converted, err := convertValue[T](name, item)
+		if err != nil {
+			return nil, err
+		}
+		arr[idx] = converted
+	}
+
+	return arr, nil
 }
 
-func (m ArgMap) GetFloat(argName string) float64 {
-	v := m[argName]
-	if v == nil {
-		return 0.
+// GetMap returns the named values, each of the form key=value, as a map of key to value.  each value is parsed
+// according to the ArgType inferred from T, and the last value of a repeated key wins.
+func GetMap[T any](m ArgMap, name string) (map[string]T, error) {
+	entries, err := GetArray[string](m, name)
+	if err != nil {
+		return nil, err
 	}
 
-	return v.(float64)
+	var zero T
+	argType := InferArgType(zero)
+	result := map[string]T{}
+	for _, entry := range entries {
+		key, text, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("value \"%s\" of %s is not of the form key=value", entry, name)
+		}
+
+		parsedValue, err := GetValueFromString(argType, text)
+		if err != nil {
+			return nil, fmt.Errorf("value of key \"%s\" of %s: %w", key, name, err)
+		}
+
+		value, err := convertValue[T](name, parsedValue)
+		if err != nil {
+			return nil, err
+		}
+		result[key] = value
+	}
+
+	return result, nil
 }
 
-func (m ArgMap) GetBool(argName string) bool {
-	v := m[argName]
-	if v == nil {
-		return false
+// Lookup returns the named value, and whether it is present.  a flag without a DefaultValue which was not given is not
+// present.
+func (m ArgMap) Lookup(name string) (any, bool) {
+	value, ok := m[name]
+	if !ok || value == nil {
+		return nil, false
 	}
 
-	return v.(bool)
+	return value, true
 }
 
-func (m ArgMap) GetStringArray(argName string) []string {
-	v := m[argName]
-	if v == nil {
-		return []string{}
+// ValueSources maps the names (and short names) of a command's flags and arguments to the sources of their values.  it
+// is held apart from the ArgMap, such that the values may be ranged over, serialized or rewritten by middleware alone.
+type ValueSources map[string]ValueSource
+
+type sourcesKey struct{}
+
+// WasSet returns true if the named flag or argument was given explicitly, rather than populated from its DefaultValue or
+// another fallback (see Source)
+func (s ValueSources) WasSet(name string) bool {
+	return s.Source(name) == ValueSourceCommandLine
+}
+
+// Source returns the source of the named value, or ValueSourceNone if there is no value
+func (s ValueSources) Source(name string) ValueSource {
+	return s[name]
+}
+
+// ContextSources returns the sources of the values supplied to an OnExecuteContext handler, given the handler's
+// context.  OnExecute handlers receive no context, and must be converted to OnExecuteContext or OnStream in order to tell
+// a flag given on the command line apart from one holding its DefaultValue.
+func ContextSources(ctx context.Context) ValueSources {
+	sources, _ := ctx.Value(sourcesKey{}).(ValueSources)
+	if sources == nil {
+		return ValueSources{}
 	}
 
-	anyArr := v.([]any)
-	arr := make([]string, len(anyArr))
+	return sources
+}
+
+// set records the source of the values under the keys
+func (s ValueSources) set(source ValueSource, keys ...string) {
+	for _, key := range keys {
+		s[key] = source
+	}
+}
 
-	for idx, item := range anyArr {
-		arr[idx] = item.(string)
+// convertValue returns the value as a T, converting between types of the same kind
+func convertValue[T any](name string, value any) (T, error) {
+	if converted, ok := value.(T); ok {
+		return converted, nil
 	}
 
-	return arr
+	var zero T
+	targetType := reflect.TypeOf((*T)(nil)).Elem()
+	source := reflect.ValueOf(value)
+	if sameKind(source.Kind(), targetType.Kind()) && source.CanConvert(targetType) {
+		return source.Convert(targetType).Interface().(T), nil
+	}
+
+	return zero, fmt.Errorf("%s is a %T, not a %s", name, value, targetType)
 }
 
-func (m ArgMap) GetIntArray(argName string) []int {
-	v := m[argName]
-	if v == nil {
-		return []int{}
+// sameKind returns true if values of kind a may be converted to kind b without changing their meaning
+func sameKind(a reflect.Kind, b reflect.Kind) bool {
+	group := func(kind reflect.Kind) int {
+		switch kind {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return 1
+		case reflect.Float32, reflect.Float64:
+			return 2
+		case reflect.String:
+			return 3
+		case reflect.Bool:
+			return 4
+		}
+		return 0
 	}
 
-	anyArr := v.([]any)
-	arr := make([]int, len(anyArr))
+	return group(a) != 0 && group(a) == group(b)
+}
 
-	for idx, item := range anyArr {
-		arr[idx] = item.(int)
+func (m ArgMap) GetString(argName string) string {
+	v, _ := Get[string](m, argName)
+	return v
+}
+
+func (m ArgMap) GetInt(argName string) int {
+	v, _ := Get[int](m, argName)
+	return v
+}
+
+func (m ArgMap) GetFloat(argName string) float64 {
+	v, _ := Get[float64](m, argName)
+	return v
+}
+
+func (m ArgMap) GetBool(argName string) bool {
+	v, _ := Get[bool](m, argName)
+	return v
+}
+
+func (m ArgMap) GetDuration(argName string) time.Duration {
+	v, _ := Get[time.Duration](m, argName)
+	return v
+}
+
+func (m ArgMap) GetTime(argName string) time.Time {
+	v, _ := Get[time.Time](m, argName)
+	return v
+}
+
+func (m ArgMap) GetByteSize(argName string) ByteSize {
+	v, _ := Get[ByteSize](m, argName)
+	return v
+}
+
+func (m ArgMap) GetIP(argName string) net.IP {
+	v, _ := Get[net.IP](m, argName)
+	return v
+}
+
+func (m ArgMap) GetCIDR(argName string) *net.IPNet {
+	v, _ := Get[*net.IPNet](m, argName)
+	return v
+}
+
+func (m ArgMap) GetURL(argName string) *url.URL {
+	v, _ := Get[*url.URL](m, argName)
+	return v
+}
+
+func (m ArgMap) GetRegexp(argName string) *regexp.Regexp {
+	v, _ := Get[*regexp.Regexp](m, argName)
+	return v
+}
+
+func (m ArgMap) GetStringArray(argName string) []string {
+	v, err := GetArray[string](m, argName)
+	if err != nil {
+		return []string{}
 	}
+	return v
+}
 
-	return arr
+func (m ArgMap) GetIntArray(argName string) []int {
+	v, err := GetArray[int](m, argName)
+	if err != nil {
+		return []int{}
+	}
+	return v
 }
 
 func (m ArgMap) GetFloatArray(argName string) []float64 {
-	v := m[argName]
-	if v == nil {
+	v, err := GetArray[float64](m, argName)
+	if err != nil {
 		return []float64{}
 	}
+	return v
+}
 
-	anyArr := v.([]any)
-	arr := make([]float64, len(anyArr))
-
-	for idx, item := range anyArr {
-		arr[idx] = item.(float64)
+// GetStringMap returns the named key=value values as a map (see GetMap)
+func (m ArgMap) GetStringMap(argName string) map[string]string {
+	v, err := GetMap[string](m, argName)
+	if err != nil {
+		return map[string]string{}
 	}
-
-	return arr
+	return v
 }
@@ -1,6 +1,11 @@
 package commander
 
-import "reflect"
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
 
 type ArgType string
 
@@ -10,8 +15,36 @@ const (
 	ArgTypeFloat       ArgType = "FLOAT"
 	ArgTypeString      ArgType = "STRING"
 	ArgTypeBool        ArgType = "BOOL"
+	ArgTypeCount       ArgType = "COUNT"    // int, the number of times a flag is given, ex. -vvv
+	ArgTypeDuration    ArgType = "DURATION" // time.Duration, ex. 1h30m
+	ArgTypeTime        ArgType = "TIME"     // time.Time, in RFC3339 form, a date, or relative to now, ex. -2h
+	ArgTypeByteSize    ArgType = "SIZE"     // ByteSize, ex. 10MiB or 1.5GB
+	ArgTypeIP          ArgType = "IP"       // net.IP
+	ArgTypeCIDR        ArgType = "CIDR"     // *net.IPNet, ex. 10.0.0.0/8
+	ArgTypeURL         ArgType = "URL"      // *url.URL, which must be absolute
+	ArgTypeRegexp      ArgType = "REGEXP"   // *regexp.Regexp
 )
 
+// ArgTypeDefinition describes how the values of an ArgType are parsed, formatted, validated and completed.  the
+// built-in types are defined in the same way as those registered by an application with RegisterArgType.
+type ArgTypeDefinition struct {
+	Name      ArgType
+	ValueType reflect.Type                   // type of the values returned by Parse, by which InferArgType recognizes them
+	Parse     func(text string) (any, error) // parses a value from the command line
+	Format    func(value any) string         // optional, formats a value for help and completion, defaults to fmt.Sprint
+	Validate  func(value any) error          // optional, checks a parsed value, as well as each value of OneOf
+	Completer Completer                      // optional, completes values of flags and arguments without a Completer or OneOf
+
+	notInferred bool // values of the ValueType are not inferred to be of this type, as with COUNT, whose values are ints
+}
+
+// argTypeRegistry holds the definition of every known ArgType
+type argTypeRegistry struct {
+	lock   sync.RWMutex
+	byName map[ArgType]*ArgTypeDefinition
+	byType map[reflect.Type]*ArgTypeDefinition
+}
+
 var (
 	intType    reflect.Type = reflect.TypeOf(int(1))
 	floatType  reflect.Type = reflect.TypeOf(float64(1))
@@ -19,36 +52,181 @@ var (
 	boolType   reflect.Type = reflect.TypeOf(false)
 )
 
+var argTypes = newArgTypeRegistry(builtinArgTypes()...)
+
+// builtinArgTypes returns the definitions of the built-in types
+func builtinArgTypes() []*ArgTypeDefinition {
+	// counts are ints, and thus not inferred from a value
+	countType := NewArgType(ArgTypeCount, parseInt)
+	countType.notInferred = true
+
+	timeType := NewArgType(ArgTypeTime, parseTime)
+	timeType.Format = func(value any) string {
+		return value.(time.Time).Format(time.RFC3339)
+	}
+
+	return []*ArgTypeDefinition{
+		NewArgType(ArgTypeInt, parseInt),
+		NewArgType(ArgTypeFloat, parseFloat),
+		NewArgType(ArgTypeString, func(text string) (string, error) { return text, nil }),
+		NewArgType(ArgTypeBool, parseBool),
+		countType,
+		NewArgType(ArgTypeDuration, parseDuration),
+		timeType,
+		NewArgType(ArgTypeByteSize, ParseByteSize),
+		NewArgType(ArgTypeIP, parseIP),
+		NewArgType(ArgTypeCIDR, parseCIDR),
+		NewArgType(ArgTypeURL, parseURL),
+		NewArgType(ArgTypeRegexp, parseRegexp),
+	}
+}
+
+// NewArgType returns the definition of an ArgType whose values are of type T, to be registered with RegisterArgType.
+// Format, Validate and Completer may be assigned to the definition before it is registered.
+func NewArgType[T any](name ArgType, parse func(text string) (T, error)) *ArgTypeDefinition {
+	return &ArgTypeDefinition{
+		Name:      name,
+		ValueType: reflect.TypeOf((*T)(nil)).Elem(),
+		Parse: func(text string) (any, error) {
+			value, err := parse(text)
+			if err != nil {
+				return nil, err
+			}
+			return value, nil
+		},
+	}
+}
+
+// RegisterArgType makes an ArgType available to flags and arguments.  an error is returned if the definition is
+// incomplete, or if its name or value type is already registered.
+func RegisterArgType(definition *ArgTypeDefinition) error {
+	return argTypes.register(definition)
+}
+
+// LookupArgType returns the definition of the named ArgType, if it is registered
+func LookupArgType(name ArgType) (*ArgTypeDefinition, bool) {
+	argTypes.lock.RLock()
+	defer argTypes.lock.RUnlock()
+
+	definition, ok := argTypes.byName[name]
+	return definition, ok
+}
+
+func newArgTypeRegistry(definitions ...*ArgTypeDefinition) *argTypeRegistry {
+	r := &argTypeRegistry{
+		byName: map[ArgType]*ArgTypeDefinition{},
+		byType: map[reflect.Type]*ArgTypeDefinition{},
+	}
+	for _, definition := range definitions {
+		err := r.register(definition)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	return r
+}
+
+func (r *argTypeRegistry) register(definition *ArgTypeDefinition) error {
+	if definition.Name == ArgTypeUnspecified {
+		return fmt.Errorf("arg type must have a non-empty name")
+	}
+
+	if definition.Parse == nil || definition.ValueType == nil {
+		return fmt.Errorf("arg type %s must specify Parse and ValueType", definition.Name)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, exists := r.byName[definition.Name]; exists {
+		return fmt.Errorf("arg type %s is already registered", definition.Name)
+	}
+
+	if existing, exists := r.byType[definition.ValueType]; exists && !definition.notInferred {
+		return fmt.Errorf("values of type %s already belong to arg type %s", definition.ValueType, existing.Name)
+	}
+
+	r.byName[definition.Name] = definition
+	if !definition.notInferred {
+		r.byType[definition.ValueType] = definition
+	}
+	return nil
+}
+
+// lookupValueType returns the ArgType whose values are exactly of the type
+func lookupValueType(valueType reflect.Type) (ArgType, bool) {
+	argTypes.lock.RLock()
+	defer argTypes.lock.RUnlock()
+
+	definition, ok := argTypes.byType[valueType]
+	if !ok {
+		return ArgTypeUnspecified, false
+	}
+
+	return definition.Name, true
+}
+
 // InferArgType infers the ArgType based on the variable type, or returns ArgTypeUnspecified
 // if the variable type does not match one of the known values.
 func InferArgType(value any) ArgType {
-	switch value.(type) {
-	case int:
+	if value == nil {
+		return ArgTypeUnspecified
+	}
+
+	baseType := reflect.TypeOf(value)
+	if argType, ok := lookupValueType(baseType); ok {
+		return argType
+	}
+
+	if baseType.ConvertibleTo(intType) {
 		return ArgTypeInt
-	case float64:
+	}
+
+	if baseType.ConvertibleTo(floatType) {
 		return ArgTypeFloat
-	case string:
+	}
+
+	if baseType.ConvertibleTo(stringType) {
 		return ArgTypeString
-	case bool:
+	}
+
+	if baseType.ConvertibleTo(boolType) {
 		return ArgTypeBool
-	default:
-		baseType := reflect.TypeOf(value)
-		if baseType.ConvertibleTo(intType) {
-			return ArgTypeInt
-		}
+	}
 
-		if baseType.ConvertibleTo(floatType) {
-			return ArgTypeFloat
-		}
+	return ArgTypeUnspecified
+}
 
-		if baseType.ConvertibleTo(stringType) {
-			return ArgTypeString
-		}
+// label returns the label describing values of the type in help, or an empty string for types which need no label
+func (t ArgType) label() string {
+	if t == ArgTypeUnspecified || t == ArgTypeString || t == ArgTypeBool || t == ArgTypeCount {
+		return ""
+	}
 
-		if baseType.ConvertibleTo(boolType) {
-			return ArgTypeBool
-		}
+	return string(t)
+}
 
-		return ArgTypeUnspecified
+// completer returns the default Completer of the type, if it has one
+func (t ArgType) completer() Completer {
+	definition, ok := LookupArgType(t)
+	if !ok {
+		return nil
+	}
+
+	return definition.Completer
+}
+
+// validateValue returns an error if the value isn't of the type, or is rejected by the type's Validate function
+func (t ArgType) validateValue(value any) error {
+	if inferred := InferArgType(value); inferred != t {
+		return fmt.Errorf("value \"%v\" did not match the argument type \"%s\"", value, t)
+	}
+
+	definition, ok := LookupArgType(t)
+	if ok && definition.Validate != nil {
+		return definition.Validate(value)
 	}
+
+	return nil
 }
@@ -2,6 +2,7 @@ package commander
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 
 	ns "github.com/hashibuto/nilshell"
@@ -15,6 +16,14 @@ type Argument struct {
 	AllowMultiple bool  // if enabled, will be returned as an array of ArgType
 	OneOf         []any // if specified, value must belong to collection
 	Completer     Completer
+
+	// constraints which each value must satisfy
+	Min       any            // minimum value of a numeric, DURATION, SIZE or TIME argument
+	Max       any            // maximum value of a numeric, DURATION, SIZE or TIME argument
+	Pattern   *regexp.Regexp // the value, as given on the command line, must match
+	MinLen    int            // minimum length of the value, as given on the command line
+	MaxLen    int            // maximum length of the value, as given on the command line
+	Validator func(value any) error
 }
 
 // Validate returns an error if any part of the argument is invalid
@@ -28,21 +37,46 @@ func (a *Argument) Validate() error {
 		a.ArgType = ArgTypeString
 	}
 
+	if _, ok := LookupArgType(a.ArgType); !ok {
+		return fmt.Errorf("unknown argument type \"%s\" in argument %s", a.ArgType, a.Name)
+	}
+
 	for _, oneOf := range a.OneOf {
-		inferredType := InferArgType(oneOf)
-		if inferredType != a.ArgType {
-			return fmt.Errorf("value in OneOf \"%v\" did not match the argument type \"%s\"", oneOf, a.ArgType)
+		err := a.ArgType.validateValue(oneOf)
+		if err != nil {
+			return fmt.Errorf("invalid OneOf in argument %s: %w", a.Name, err)
 		}
-		a.ArgType = inferredType
+	}
+
+	if a.ArgType == ArgTypeCount {
+		return fmt.Errorf("argument %s cannot be a count, which only applies to flags", a.Name)
 	}
 
 	if a.AllowMultiple && a.ArgType == ArgTypeBool {
 		return fmt.Errorf("allowing multiple boolean values doesn't make sense")
 	}
 
+	err := a.constraints().validateConstraints()
+	if err != nil {
+		return fmt.Errorf("invalid constraints in argument %s: %w", a.Name, err)
+	}
+
 	return nil
 }
 
+// constraints returns the constraints which each value of the argument must satisfy
+func (a *Argument) constraints() *valueConstraints {
+	return &valueConstraints{
+		argType:  a.ArgType,
+		min:      a.Min,
+		max:      a.Max,
+		pattern:  a.Pattern,
+		minLen:   a.MinLen,
+		maxLen:   a.MaxLen,
+		validate: a.Validator,
+	}
+}
+
 // GetValueFromString parses the provided value according to the argument's underlying data type and returns that parsed value, or an error
 func (a *Argument) GetValueFromString(value string) (any, error) {
 	return GetValueFromString(a.ArgType, value)
@@ -51,15 +85,20 @@ func (a *Argument) GetValueFromString(value string) (any, error) {
 func (a *Argument) PopulateMap(value string, target map[string]any) error {
 	parsedValue, err := GetValueFromString(a.ArgType, value)
 	if err != nil {
-		return err
+		return fmt.Errorf("invalid value for argument %s: %w", a.Name, err)
 	}
 
 	if a.OneOf != nil {
 		if !MatchesOneOf(a.OneOf, parsedValue) {
-			return fmt.Errorf("\"%s\" does not belong to the collection defined by the argument", parsedValue)
+			return fmt.Errorf("invalid value for argument %s: \"%s\" does not belong to the collection defined by the argument", a.Name, FormatValue(parsedValue))
 		}
 	}
 
+	err = a.constraints().check(value, parsedValue)
+	if err != nil {
+		return fmt.Errorf("invalid value for argument %s: %w", a.Name, err)
+	}
+
 	if a.AllowMultiple {
 		if _, ok := target[a.Name]; !ok {
 			target[a.Name] = []any{}
@@ -73,11 +112,21 @@ func (a *Argument) PopulateMap(value string, target map[string]any) error {
 	return nil
 }
 
+// SuggestValues suggests values of the argument beginning with prefix, omitting any which would violate its constraints
 func (a *Argument) SuggestValues(prefix string) *ns.Suggestions {
+	constraints := a.constraints()
+	if constraints.isEmpty() {
+		return a.suggestValues(prefix)
+	}
+
+	return constraints.filter(a.suggestValues(prefix))
+}
+
+func (a *Argument) suggestValues(prefix string) *ns.Suggestions {
 	if a.OneOf != nil {
 		suggestions := ns.NewSuggestions()
 		for _, oneOf := range a.OneOf {
-			oneOfStr := fmt.Sprintf("%s", oneOf)
+			oneOfStr := FormatValue(oneOf)
 			if strings.HasPrefix(oneOfStr, prefix) {
 				suggestions.Add(ns.NewSuggestion(oneOfStr, oneOfStr))
 			}
@@ -90,5 +139,9 @@ func (a *Argument) SuggestValues(prefix string) *ns.Suggestions {
 		return a.Completer(prefix)
 	}
 
+	if completer := a.ArgType.completer(); completer != nil {
+		return completer(prefix)
+	}
+
 	return nil
 }
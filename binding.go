@@ -0,0 +1,248 @@
+package commander
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Binding binds the flags and arguments of a command to the fields of an options struct, and invokes a typed handler
+// with the populated struct.  a Binding is created with Bind, and assigned to Command.OnBind.
+//
+// fields of the options struct are bound using tags:
+//
+//	flag:"output,o"      binds the field to a flag with the name "output" and short name "o" (the short name is optional)
+//	arg:"resource-type"  binds the field to a positional argument, in the order in which the fields are declared
+//	desc:"..."           describes the flag or argument in help
+//	default:"table"      default value of a flag
+//	oneof:"json,yaml"    comma separated list of values which the flag or argument is restricted to
+//	required:"true"      the flag must be specified
+//	env:"APP_OUTPUT"     environment variable from which the flag is read when it isn't specified
+//
+// a field may be a string, bool, any int or float type, time.Duration, time.Time, ByteSize, net.IP, *net.IPNet,
+// *url.URL, *regexp.Regexp, the value type of a registered ArgType, or a slice of one of these, which allows multiple
+// values.
+// untagged fields are ignored.
+type Binding struct {
+	optionsType reflect.Type
+	handler     func(ex *Execution, options reflect.Value) error
+	fields      []*boundField
+	derived     bool
+}
+
+// boundField associates a field of the options struct with the flag or argument it is bound to
+type boundField struct {
+	index int
+	key   string // key of the value within the ArgMap
+}
+
+// Bind returns a Binding which invokes handler with a new instance of T, populated from the command's flags and
+// arguments.  T must be a struct type.
+func Bind[T any](handler func(ex *Execution, options *T) error) *Binding {
+	return &Binding{
+		optionsType: reflect.TypeOf((*T)(nil)).Elem(),
+		handler: func(ex *Execution, options reflect.Value) error {
+			return handler(ex, options.Interface().(*T))
+		},
+	}
+}
+
+// derive returns the flags and arguments described by the tags of the options struct, or an error if a tag doesn't
+// match the type of its field
+func (b *Binding) derive() ([]*Flag, []*Argument, error) {
+	if b.optionsType.Kind() != reflect.Struct {
+		return nil, nil, fmt.Errorf("options type %s is not a struct", b.optionsType)
+	}
+
+	flags := []*Flag{}
+	arguments := []*Argument{}
+	b.fields = []*boundField{}
+	for i := 0; i < b.optionsType.NumField(); i++ {
+		field := b.optionsType.Field(i)
+		flagTag, isFlag := field.Tag.Lookup("flag")
+		argTag, isArg := field.Tag.Lookup("arg")
+		if !isFlag && !isArg {
+			continue
+		}
+
+		if isFlag && isArg {
+			return nil, nil, fmt.Errorf("field %s cannot be both a flag and an argument", field.Name)
+		}
+
+		if !field.IsExported() {
+			return nil, nil, fmt.Errorf("field %s must be exported in order to be bound", field.Name)
+		}
+
+		argType, allowMultiple := fieldArgType(field.Type)
+		if argType == ArgTypeUnspecified {
+			return nil, nil, fmt.Errorf("field %s has unsupported type %s", field.Name, field.Type)
+		}
+
+		var oneOf []any
+		if tag, ok := field.Tag.Lookup("oneof"); ok {
+			values, err := parseTagValues(argType, tag)
+			if err != nil {
+				return nil, nil, fmt.Errorf("field %s has an invalid oneof tag: %w", field.Name, err)
+			}
+			oneOf = values
+		}
+
+		if isArg {
+			if _, ok := field.Tag.Lookup("default"); ok {
+				return nil, nil, fmt.Errorf("field %s is an argument, and cannot have a default value", field.Name)
+			}
+
+			arguments = append(arguments, &Argument{
+				Name:          argTag,
+				Description:   field.Tag.Get("desc"),
+				ArgType:       argType,
+				AllowMultiple: allowMultiple,
+				OneOf:         oneOf,
+			})
+			b.fields = append(b.fields, &boundField{index: i, key: argTag})
+			continue
+		}
+
+		name, shortName, _ := strings.Cut(flagTag, ",")
+		flag := &Flag{
+			Name:          name,
+			ShortName:     shortName,
+			Description:   field.Tag.Get("desc"),
+			ArgType:       argType,
+			AllowMultiple: allowMultiple,
+			OneOf:         oneOf,
+			IsRequired:    field.Tag.Get("required") == "true",
+			EnvVar:        field.Tag.Get("env"),
+		}
+
+		if tag, ok := field.Tag.Lookup("default"); ok {
+			values, err := parseTagValues(argType, tag)
+			if err != nil {
+				return nil, nil, fmt.Errorf("field %s has an invalid default tag: %w", field.Name, err)
+			}
+
+			if allowMultiple {
+				flag.DefaultValue = values
+			} else if len(values) != 1 {
+				return nil, nil, fmt.Errorf("field %s has an invalid default tag: expected a single value", field.Name)
+			} else {
+				flag.DefaultValue = values[0]
+			}
+		}
+
+		flags = append(flags, flag)
+		b.fields = append(b.fields, &boundField{index: i, key: name})
+	}
+
+	return flags, arguments, nil
+}
+
+// execute populates a new instance of the options struct from the execution's arguments, and invokes the handler
+func (b *Binding) execute(ex *Execution) error {
+	options := reflect.New(b.optionsType)
+	for _, field := range b.fields {
+		value, ok := ex.Args[field.key]
+		if !ok || value == nil {
+			continue
+		}
+
+		err := setField(options.Elem().Field(field.index), value)
+		if err != nil {
+			return fmt.Errorf("unable to bind %s: %w", field.key, err)
+		}
+	}
+
+	return b.handler(ex, options)
+}
+
+// fieldArgType returns the ArgType corresponding to the field type, and whether the field holds multiple values
+func fieldArgType(fieldType reflect.Type) (ArgType, bool) {
+	if argType, ok := lookupValueType(fieldType); ok {
+		return argType, false
+	}
+
+	if fieldType.Kind() == reflect.Slice {
+		argType, allowMultiple := fieldArgType(fieldType.Elem())
+		if allowMultiple || argType == ArgTypeBool {
+			return ArgTypeUnspecified, false
+		}
+		return argType, true
+	}
+
+	switch fieldType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return ArgTypeInt, false
+	case reflect.Float32, reflect.Float64:
+		return ArgTypeFloat, false
+	case reflect.String:
+		return ArgTypeString, false
+	case reflect.Bool:
+		return ArgTypeBool, false
+	}
+
+	return ArgTypeUnspecified, false
+}
+
+// parseTagValues parses each of the comma separated values of a tag
+func parseTagValues(argType ArgType, tag string) ([]any, error) {
+	values := []any{}
+	for _, text := range strings.Split(tag, ",") {
+		value, err := GetValueFromString(argType, text)
+		if err != nil {
+			return nil, fmt.Errorf("\"%s\": %w", text, err)
+		}
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+// setField assigns a value from an ArgMap to a field
+func setField(field reflect.Value, value any) error {
+	if reflect.TypeOf(value).AssignableTo(field.Type()) {
+		field.Set(reflect.ValueOf(value))
+		return nil
+	}
+
+	if field.Kind() == reflect.Slice {
+		values, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("expected multiple values, got %T", value)
+		}
+
+		slice := reflect.MakeSlice(field.Type(), len(values), len(values))
+		for i, value := range values {
+			err := setField(slice.Index(i), value)
+			if err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+		return nil
+	}
+
+	switch v := value.(type) {
+	case int:
+		if field.CanInt() {
+			field.SetInt(int64(v))
+			return nil
+		}
+	case float64:
+		if field.CanFloat() {
+			field.SetFloat(v)
+			return nil
+		}
+	case string:
+		if field.Kind() == reflect.String {
+			field.SetString(v)
+			return nil
+		}
+	case bool:
+		if field.Kind() == reflect.Bool {
+			field.SetBool(v)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("value of type %T cannot be assigned to a field of type %s", value, field.Type())
+}
@@ -0,0 +1,78 @@
+package commander
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ByteSize is a number of bytes, the value of an ArgTypeByteSize flag or argument
+type ByteSize int64
+
+// byteUnit is a unit by which a ByteSize may be expressed
+type byteUnit struct {
+	suffix string
+	size   ByteSize
+}
+
+// byteUnits lists the units understood by ParseByteSize, largest first within the binary and decimal units
+var byteUnits = []byteUnit{
+	{"PiB", 1 << 50}, {"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10},
+	{"PB", 1e15}, {"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3},
+	{"B", 1},
+}
+
+// ParseByteSize parses a number of bytes with an optional unit, ex. 512, 10MiB or 1.5GB.  binary units (KiB, MiB, ...)
+// are powers of 1024, while decimal units (KB, MB, ...) are powers of 1000.  units are not case sensitive, and the
+// trailing B of a unit may be omitted.
+func ParseByteSize(text string) (ByteSize, error) {
+	text = strings.TrimSpace(text)
+	number := strings.TrimRightFunc(text, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	suffix := strings.TrimSpace(text[len(number):])
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("value could not be parsed to a size")
+	}
+
+	unit, ok := findByteUnit(suffix)
+	if !ok {
+		return 0, fmt.Errorf("unknown size unit \"%s\"", suffix)
+	}
+
+	size := value * float64(unit)
+	if size > math.MaxInt64 {
+		return 0, fmt.Errorf("size is too large")
+	}
+
+	return ByteSize(size), nil
+}
+
+// findByteUnit returns the size of the unit with the suffix, ignoring case and a missing trailing B
+func findByteUnit(suffix string) (ByteSize, bool) {
+	if suffix == "" {
+		return 1, true
+	}
+
+	for _, unit := range byteUnits {
+		if strings.EqualFold(suffix, unit.suffix) || strings.EqualFold(suffix+"B", unit.suffix) {
+			return unit.size, true
+		}
+	}
+
+	return 0, false
+}
+
+// String formats the size using the largest unit which divides it exactly, such that it parses back to the same size
+func (s ByteSize) String() string {
+	for _, unit := range byteUnits {
+		if s != 0 && s%unit.size == 0 {
+			return fmt.Sprintf("%d%s", s/unit.size, unit.suffix)
+		}
+	}
+
+	return fmt.Sprintf("%dB", int64(s))
+}
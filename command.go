@@ -1,8 +1,13 @@
 package commander
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"slices"
 	"strings"
+	"time"
 
 	ns "github.com/hashibuto/nilshell"
 )
@@ -13,9 +18,41 @@ type Command struct {
 	Flags       []*Flag
 	Arguments   []*Argument
 	SubCommands []*Command
-	OnExecute   func(c *Command, args ArgMap, capturedInput []byte) error
+	Timeout     time.Duration // if set, the context supplied to OnExecuteContext is cancelled once the duration elapses
+
+	// OnExecute handlers write their output to os.Stdout and os.Stderr, which are replaced process-wide while the handler
+	// runs in order to capture it.  handlers therefore run one at a time, anything written to os.Stdout or os.Stderr by
+	// another goroutine meanwhile is captured along with it, and they cannot run in the background.  OnStream, which
+	// writes to the streams of the execution, should be preferred.
+	OnExecute func(c *Command, args ArgMap, capturedInput []byte) error
+
+	// OnExecuteContext may be implemented in place of OnExecute, for commands which should be cancellable.  the context
+	// is cancelled when the user interrupts the command (ctrl+c), or when the Timeout elapses, and carries the sources of
+	// the values (see ContextSources).  its output is captured in the same way as that of OnExecute.
+	OnExecuteContext func(ctx context.Context, c *Command, args ArgMap, capturedInput []byte) error
+
+	// OnStream may be implemented in place of OnExecute, for commands which read their input and write their output
+	// incrementally.  pipeline stages run concurrently, thus a streaming command begins receiving input as soon as the
+	// previous stage produces it.
+	OnStream func(ex *Execution) error
+
+	// OnBind may be implemented in place of OnExecute, in order to receive the flags and arguments bound to the fields
+	// of an options struct, from which the Flags and Arguments of the command are derived (see Bind)
+	OnBind *Binding
+
+	// flag groups constrain the flags which may be given together, naming the flags by their long names.  they are
+	// checked once the flags have been classified.
+	MutuallyExclusive [][]string          // at most one flag of each group may be given
+	RequiredTogether  [][]string          // either all or none of the flags of each group must be given
+	OneRequired       [][]string          // at least one flag of each group must be given
+	RequiredIf        map[string][]string // maps a condition, "flag=value" or just "flag" (if given), to the flags it requires
+
+	// Middleware wraps the execution of this command, inside of any middleware configured on the commander.  the first
+	// middleware listed is the outermost.
+	Middleware []Middleware
 
 	Commander  *Commander
+	path       string // the tokens which locate the command, ex. "get process"
 	commandMap map[string]*Command
 	flagMap    map[string]*Flag
 	argMap     map[string]*Argument
@@ -32,18 +69,43 @@ func (c *Command) Validate(parentFlags map[string]struct{}) error {
 	c.flagMap = map[string]*Flag{}
 	c.argMap = map[string]*Argument{}
 
+	// the flags and arguments are only derived once, since commands may be validated more than once
+	if c.OnBind != nil && !c.OnBind.derived {
+		flags, arguments, err := c.OnBind.derive()
+		if err != nil {
+			return fmt.Errorf("command \"%s\" - %w", c.Name, err)
+		}
+		c.Flags = append(c.Flags, flags...)
+		c.Arguments = append(c.Arguments, arguments...)
+		c.OnBind.derived = true
+	}
+
 	if len(c.SubCommands) > 0 && len(c.Arguments) > 0 {
 		return fmt.Errorf("command \"%s\" cannot contain both subcommands and positional arguments", c.Name)
 	}
 
-	if len(c.SubCommands) > 0 && c.OnExecute != nil {
+	if len(c.SubCommands) > 0 && c.IsExecutable() {
 		return fmt.Errorf("command \"%s\" cannot contain both subcommands and an OnExecute handler", c.Name)
 	}
 
-	if len(c.SubCommands) == 0 && c.OnExecute == nil {
+	if len(c.SubCommands) == 0 && !c.IsExecutable() {
 		return fmt.Errorf("command \"%s\" does not implement an OnExecute handler", c.Name)
 	}
 
+	numHandlers := 0
+	for _, implemented := range []bool{c.OnExecute != nil, c.OnExecuteContext != nil, c.OnStream != nil, c.OnBind != nil} {
+		if implemented {
+			numHandlers++
+		}
+	}
+	if numHandlers > 1 {
+		return fmt.Errorf("command \"%s\" must implement only one of OnExecute, OnExecuteContext, OnStream or OnBind", c.Name)
+	}
+
+	if c.Timeout < 0 {
+		return fmt.Errorf("command \"%s\" cannot have a negative timeout", c.Name)
+	}
+
 	for _, arg := range c.Arguments {
 		if _, exists := parentFlags[arg.Name]; exists {
 			return fmt.Errorf("argument name \"%s\" on command \"%s\" is already defined as parent command flag", arg.Name, c.Name)
@@ -115,6 +177,11 @@ func (c *Command) Validate(parentFlags map[string]struct{}) error {
 		}
 	}
 
+	err := c.validateFlagGroups(parentFlags)
+	if err != nil {
+		return err
+	}
+
 	for _, subCmd := range c.SubCommands {
 		if _, exists := c.commandMap[subCmd.Name]; exists {
 			return fmt.Errorf("sub-command \"%s\" under \"%s\" is defined multiple times", subCmd.Name, c.Name)
@@ -134,10 +201,136 @@ func (c *Command) Validate(parentFlags map[string]struct{}) error {
 	return nil
 }
 
+// IsExecutable returns true if the command implements a handler
+func (c *Command) IsExecutable() bool {
+	return c.OnExecute != nil || c.OnExecuteContext != nil || c.OnStream != nil || c.OnBind != nil
+}
+
+// isBuffered returns true if the command implements one of the legacy handlers, which write to the process' stdout and
+// stderr rather than to the streams of the execution
+func (c *Command) isBuffered() bool {
+	return c.OnExecute != nil || c.OnExecuteContext != nil
+}
+
+// isCancellable returns true if the command implements one of the handlers which receive a context, and may thus stop
+// when it is cancelled
+func (c *Command) isCancellable() bool {
+	return c.OnExecuteContext != nil || c.OnStream != nil || c.OnBind != nil
+}
+
+// execute invokes the command's handler by way of any middleware, applying the command's timeout to the context if one
+// is set
+func (c *Command) execute(ex *Execution) error {
+	ctx := ex.Context()
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, c.Timeout, fmt.Errorf("command \"%s\" timed out after %s", c.Name, c.Timeout))
+		defer cancel()
+		ex.ctx = ctx
+	}
+
+	handler := Handler(c.handle)
+	for i := len(c.Middleware) - 1; i >= 0; i-- {
+		handler = c.Middleware[i](handler)
+	}
+	if c.Commander != nil {
+		for i := len(c.Commander.Config.Middleware) - 1; i >= 0; i-- {
+			handler = c.Commander.Config.Middleware[i](handler)
+		}
+	}
+
+	err := handler(ex)
+
+	// report the reason for cancellation rather than the generic context error
+	if err != nil && ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		return context.Cause(ctx)
+	}
+
+	return err
+}
+
+// setCommander gives the command and all of its subcommands, at any depth, a reference to the commander, and records
+// the path of each command
+func (c *Command) setCommander(commander *Commander, path string) {
+	c.Commander = commander
+	c.path = path
+	for _, sub := range c.SubCommands {
+		sub.setCommander(commander, path+" "+sub.Name)
+	}
+}
+
+// clone returns a copy of the command, along with its flags, arguments and subcommands, which may be given to a
+// commander without affecting the original
+func (c *Command) clone() *Command {
+	clone := *c
+	clone.Flags = make([]*Flag, len(c.Flags))
+	for i, flag := range c.Flags {
+		flagClone := *flag
+		clone.Flags[i] = &flagClone
+	}
+	clone.Arguments = make([]*Argument, len(c.Arguments))
+	for i, arg := range c.Arguments {
+		argClone := *arg
+		clone.Arguments[i] = &argClone
+	}
+	clone.SubCommands = make([]*Command, len(c.SubCommands))
+	for i, sub := range c.SubCommands {
+		clone.SubCommands[i] = sub.clone()
+	}
+	if c.OnBind != nil {
+		binding := *c.OnBind
+		clone.OnBind = &binding
+	}
+
+	return &clone
+}
+
+// handle invokes whichever handler the command implements
+func (c *Command) handle(ex *Execution) error {
+	if c.OnStream != nil {
+		return c.OnStream(ex)
+	}
+
+	if c.OnBind != nil {
+		return c.OnBind.execute(ex)
+	}
+
+	return c.executeBuffered(ex)
+}
+
+// executeBuffered adapts the stream based execution to the OnExecute and OnExecuteContext handlers, by reading the
+// entire input before invoking the handler, and capturing anything written to os.Stdout and os.Stderr.
+func (c *Command) executeBuffered(ex *Execution) error {
+	capturedInput, err := io.ReadAll(ex.Stdin)
+	if err != nil {
+		return err
+	}
+
+	handler := func() error {
+		if c.OnExecuteContext != nil {
+			ctx := context.WithValue(ex.Context(), sourcesKey{}, ex.Sources)
+			return c.OnExecuteContext(ctx, c, ex.Args, capturedInput)
+		}
+		return c.OnExecute(c, ex.Args, capturedInput)
+	}
+
+	return captureStdio(ex.Stdout, ex.Stderr, handler)
+}
+
 func (c *Command) Suggest(tokens []string, parentFlags []*Flag) *ns.Suggestions {
 	argNum := 0
 
 	allFlags := append(parentFlags, c.Flags...)
+	allFlagMap := map[string]*Flag{}
+	for _, f := range allFlags {
+		allFlagMap[f.Name] = f
+		if f.ShortName != "" {
+			allFlagMap[f.ShortName] = f
+		}
+	}
+
+	// flags which have been used, by long name, such that flags excluded by them aren't suggested
+	used := map[string]bool{}
 
 	noFlags := false
 	var curFlag *Flag
@@ -160,35 +353,22 @@ func (c *Command) Suggest(tokens []string, parentFlags []*Flag) *ns.Suggestions
 			}
 
 			if strings.HasPrefix(t, "-") && !strings.HasPrefix(t, "--") {
-				flagBody := t[1:]
-				// if value is already being assigned
-				if strings.Contains(flagBody, "=") {
-					continue
-				}
-				prefix := flagBody
-
 				if isFinal {
-					sugg := ns.NewSuggestions()
-					for _, f := range allFlags {
-						if strings.HasPrefix(f.ShortName, prefix) {
-							sugg.Add(ns.NewSuggestion(
-								fmt.Sprintf("%s  %s", f.GetInvocation(), f.Description),
-								fmt.Sprintf("-%s", f.ShortName),
-							))
-						}
-					}
-
-					return sugg
+					return c.suggestShortFlags(t, allFlags, allFlagMap, used)
 				}
 
-				if f, ok := c.flagMap[flagBody]; ok {
-					curFlag = f
-				}
+				curFlag = scanShortFlags(t, allFlagMap, used)
 				continue
 			}
 
 			if strings.HasPrefix(t, "--") {
 				flagBody := t[2:]
+				name := strings.SplitN(flagBody, "=", 2)[0]
+				if f, ok := allFlagMap[name]; ok && !isFinal {
+					used[f.Name] = true
+				} else if f, isNegation := negatedFlag(allFlagMap, name); isNegation && !isFinal {
+					used[f.Name] = true
+				}
 				// if value is already being assigned
 				if strings.Contains(flagBody, "=") {
 					continue
@@ -197,18 +377,28 @@ func (c *Command) Suggest(tokens []string, parentFlags []*Flag) *ns.Suggestions
 				if isFinal {
 					sugg := ns.NewSuggestions()
 					for _, f := range allFlags {
+						if f.Name == "" || c.excludes(used, f.Name) {
+							continue
+						}
 						if strings.HasPrefix(f.Name, prefix) {
 							sugg.Add(ns.NewSuggestion(
 								fmt.Sprintf("%s  %s", f.GetInvocation(), f.Description),
 								fmt.Sprintf("--%s", f.Name),
 							))
 						}
+						// boolean flags are also suggested in their negated form
+						if f.isNegatable() && strings.HasPrefix("no-"+f.Name, prefix) {
+							sugg.Add(ns.NewSuggestion(
+								fmt.Sprintf("--no-%s  %s", f.Name, f.Description),
+								fmt.Sprintf("--no-%s", f.Name),
+							))
+						}
 					}
 
 					return sugg
 				}
 
-				if f, ok := c.flagMap[flagBody]; ok {
+				if f, ok := allFlagMap[flagBody]; ok && f.takesValue() {
 					curFlag = f
 				}
 				continue
@@ -249,8 +439,118 @@ func (c *Command) Suggest(tokens []string, parentFlags []*Flag) *ns.Suggestions
 	return nil
 }
 
+// negatedFlag returns the boolean flag negated by the name, ex. the flag "timestamp" for the name "no-timestamp"
+func negatedFlag(allFlagMap map[string]*Flag, name string) (*Flag, bool) {
+	flagName, isNegation := strings.CutPrefix(name, "no-")
+	if !isNegation {
+		return nil, false
+	}
+
+	flag, ok := allFlagMap[flagName]
+	if !ok || flag.Name != flagName || !flag.isNegatable() {
+		return nil, false
+	}
+
+	return flag, true
+}
+
+// scanShortFlags notes the flags of a token holding one or more short flags as used, returning the last flag if it
+// takes its value from the next token
+func scanShortFlags(token string, allFlagMap map[string]*Flag, used map[string]bool) *Flag {
+	body := token[1:]
+	for i, char := range body {
+		flag, ok := allFlagMap[string(char)]
+		if !ok {
+			return nil
+		}
+		used[flag.Name] = true
+
+		if flag.takesValue() {
+			if len(body) == i+1 {
+				return flag
+			}
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// suggestShortFlags completes a token holding one or more short flags.  while the token is a cluster of boolean flags,
+// the token itself is suggested, along with the token extended by each other short flag.  once the token reaches a
+// flag which takes a value, the attached value is completed.
+func (c *Command) suggestShortFlags(token string, allFlags []*Flag, allFlagMap map[string]*Flag, used map[string]bool) *ns.Suggestions {
+	sugg := ns.NewSuggestions()
+	body := token[1:]
+	inCluster := map[string]bool{}
+	var last *Flag
+	for i, char := range body {
+		name := string(char)
+		flag, ok := allFlagMap[name]
+		if !ok {
+			return sugg
+		}
+		inCluster[name] = true
+		used[flag.Name] = true
+		last = flag
+
+		rest := body[i+len(name):]
+		if !flag.takesValue() && !strings.HasPrefix(rest, "=") {
+			continue
+		}
+
+		if rest == "" {
+			// the value is given by the next token
+			sugg.Add(ns.NewSuggestion(fmt.Sprintf("%s  %s", flag.GetInvocation(), flag.Description), token))
+			return sugg
+		}
+
+		prefix := token[:len(token)-len(rest)]
+		if strings.HasPrefix(rest, "=") {
+			prefix += "="
+			rest = rest[1:]
+		}
+		values := flag.SuggestValues(rest)
+		if values == nil {
+			return nil
+		}
+		for _, value := range values.Items {
+			sugg.Add(ns.NewSuggestion(value.Display, prefix+value.Value))
+		}
+		return sugg
+	}
+
+	if last != nil {
+		sugg.Add(ns.NewSuggestion(fmt.Sprintf("%s  %s", last.GetInvocation(), last.Description), token))
+	}
+	for _, f := range allFlags {
+		// only count flags may be repeated within a cluster
+		repeatable := !inCluster[f.ShortName] || f.ArgType == ArgTypeCount
+		if f.ShortName != "" && repeatable && !c.excludes(used, f.Name) {
+			sugg.Add(ns.NewSuggestion(
+				fmt.Sprintf("%s  %s", f.GetInvocation(), f.Description),
+				token+f.ShortName,
+			))
+		}
+	}
+
+	return sugg
+}
+
 // ClassifyTokens attempts to classify the token array using the defined flags and arguments, in order to populate a name to value mapping
 func (c *Command) ClassifyTokens(tokens []string, parentFlags []*Flag) (map[string]any, error) {
+	tokenMap, _, err := c.classifyTokens(tokens, parentFlags)
+	return tokenMap, err
+}
+
+// ClassifyTokensWithSources is the same as ClassifyTokens, but also returns the source of each value, which tells a flag
+// given on the command line apart from one holding its DefaultValue
+func (c *Command) ClassifyTokensWithSources(tokens []string, parentFlags []*Flag) (map[string]any, ValueSources, error) {
+	return c.classifyTokens(tokens, parentFlags)
+}
+
+// classifyTokens is the same as ClassifyTokensWithSources
+func (c *Command) classifyTokens(tokens []string, parentFlags []*Flag) (map[string]any, ValueSources, error) {
 	allFlagMap := map[string]*Flag{}
 	for k, v := range c.flagMap {
 		allFlagMap[k] = v
@@ -278,7 +578,7 @@ func (c *Command) ClassifyTokens(tokens []string, parentFlags []*Flag) (map[stri
 			// Grab the value for the active flag
 			err := curFlag.PopulateMap(t, tokenMap)
 			if err != nil {
-				return nil, fmt.Errorf("invalid value for flag %s: %w", curFlag.GetInvocation(), err)
+				return nil, nil, err
 			}
 
 			curFlag = nil
@@ -292,23 +592,13 @@ func (c *Command) ClassifyTokens(tokens []string, parentFlags []*Flag) (map[stri
 			}
 
 			if strings.HasPrefix(t, "-") && !strings.HasPrefix(t, "--") {
-				flagBody := t[1:]
-				parts := strings.SplitN(flagBody, "=", 2)
-				name = parts[0]
-				if len(parts) == 2 {
-					hasValue = true
-					value = parts[1]
-				} else {
-					hasValue = false
-				}
-
-				if len(name) > 1 {
-					return nil, fmt.Errorf("malformed flag %s, did you mean -%s", t, t)
+				pending, err := classifyShortFlags(t, allFlagMap, tokenMap)
+				if err != nil {
+					return nil, nil, err
 				}
 
-				if len(name) == 0 {
-					return nil, fmt.Errorf("missing flag name")
-				}
+				curFlag = pending
+				continue
 			}
 
 			if strings.HasPrefix(t, "--") && len(t) > 2 {
@@ -323,7 +613,7 @@ func (c *Command) ClassifyTokens(tokens []string, parentFlags []*Flag) (map[stri
 				}
 
 				if len(name) == 1 {
-					return nil, fmt.Errorf("malformed flag %s, did you mean -%s", t, name)
+					return nil, nil, fmt.Errorf("malformed flag %s, did you mean -%s", t, name)
 				}
 			}
 
@@ -331,31 +621,38 @@ func (c *Command) ClassifyTokens(tokens []string, parentFlags []*Flag) (map[stri
 			if len(name) > 0 {
 				flag, ok := allFlagMap[name]
 				if !ok {
-					return nil, fmt.Errorf("unrecognized flag %s", name)
+					negated, isNegation := negatedFlag(allFlagMap, name)
+					if !isNegation {
+						return nil, nil, fmt.Errorf("unrecognized flag %s", name)
+					}
+
+					if hasValue {
+						return nil, nil, fmt.Errorf("flag --%s does not take a value", name)
+					}
+
+					err := negated.PopulateMap("false", tokenMap)
+					if err != nil {
+						return nil, nil, err
+					}
+					continue
 				}
 
 				if hasValue {
-					err := curFlag.PopulateMap(value, tokenMap)
+					err := flag.PopulateMap(value, tokenMap)
 					if err != nil {
-						return nil, fmt.Errorf("invalid value for flag %s: %w", curFlag.GetInvocation(), err)
+						return nil, nil, err
 					}
 					continue
 				}
 
-				if flag.ArgType != ArgTypeBool {
+				if flag.takesValue() {
 					curFlag = flag
 					continue
 				}
 
-				var v string
-				if flag.DefaultValue == false {
-					v = "true"
-				} else {
-					v = "false"
-				}
-				err := flag.PopulateMap(v, tokenMap)
+				err := flag.populatePresent(tokenMap)
 				if err != nil {
-					return nil, fmt.Errorf("invalid value for flag %s: %w", flag.GetInvocation(), err)
+					return nil, nil, err
 				}
 
 				continue
@@ -363,13 +660,13 @@ func (c *Command) ClassifyTokens(tokens []string, parentFlags []*Flag) (map[stri
 		}
 
 		if len(c.Arguments) == 0 {
-			return nil, fmt.Errorf("command \"%s\" does not accept any positional arguments", c.Name)
+			return nil, nil, fmt.Errorf("command \"%s\" does not accept any positional arguments", c.Name)
 		}
 
 		var curArg *Argument
 		if argNum >= len(c.Arguments) {
 			if !c.Arguments[len(c.Arguments)-1].AllowMultiple {
-				return nil, fmt.Errorf("too many positional arguments provided")
+				return nil, nil, fmt.Errorf("too many positional arguments provided")
 			}
 
 			curArg = c.Arguments[len(c.Arguments)-1]
@@ -379,20 +676,111 @@ func (c *Command) ClassifyTokens(tokens []string, parentFlags []*Flag) (map[stri
 
 		err := curArg.PopulateMap(t, tokenMap)
 		if err != nil {
-			return nil, fmt.Errorf("invalid value for argument %s: %w", curArg.Name, err)
+			return nil, nil, err
 		}
 		argNum++
 	}
 
-	// Apply all other tokens to the map
-	for _, flag := range allFlagMap {
-		err := flag.PopulateDefault(tokenMap)
+	// everything classified so far was given on the command line
+	sources := ValueSources{}
+	for key := range tokenMap {
+		sources[key] = ValueSourceCommandLine
+	}
+
+	// Apply all other tokens to the map, in the order the flags are defined such that the first failure is reported
+	for _, flag := range append(slices.Clip(parentFlags), c.Flags...) {
+		if allFlagMap[flag.keys()[0]] != flag {
+			// shadowed by a flag of the same name, as with the help flag of each command
+			continue
+		}
+
+		source, err := flag.populateDefault(tokenMap, c.fileDefaults(flag))
 		if err != nil {
-			return nil, fmt.Errorf("command \"%s\" - %s", c.Name, err.Error())
+			return nil, nil, fmt.Errorf("command \"%s\" - %s", c.Name, err.Error())
+		}
+		if source != ValueSourceNone {
+			sources.set(source, flag.keys()...)
 		}
 	}
 
-	return tokenMap, nil
+	err := c.checkFlagGroups(tokenMap, sources, allFlagMap)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return tokenMap, sources, nil
+}
+
+// fileDefaults returns the values of the flag given by the commander's defaults file for this command, or nil
+func (c *Command) fileDefaults(flag *Flag) []string {
+	if c.Commander == nil {
+		return nil
+	}
+
+	values, ok := c.Commander.defaults.lookup(c.path, flag.Name)
+	if !ok {
+		return nil
+	}
+
+	return values
+}
+
+// classifyShortFlags populates the flags of a token holding one or more short flags.  boolean flags may be clustered
+// (ex. -vf), and the last flag of the cluster may be given an attached value (ex. -ojson or -o=json).  if the last flag
+// takes its value from the next token, it is returned.
+func classifyShortFlags(token string, allFlagMap map[string]*Flag, tokenMap map[string]any) (*Flag, error) {
+	body := token[1:]
+	if len(body) == 0 {
+		return nil, fmt.Errorf("missing flag name")
+	}
+
+	pending, err := classifyShortFlagCluster(token, allFlagMap, tokenMap)
+	if err != nil {
+		// a long flag given with a single dash is most likely a mistake
+		name := strings.SplitN(body, "=", 2)[0]
+		if long, isLong := allFlagMap[name]; isLong && long.Name == name {
+			return nil, fmt.Errorf("%w, did you mean --%s", err, long.Name)
+		}
+		return nil, err
+	}
+
+	return pending, nil
+}
+
+// classifyShortFlagCluster populates the flags of the cluster, returning the last flag if it takes its value from the
+// next token
+func classifyShortFlagCluster(token string, allFlagMap map[string]*Flag, tokenMap map[string]any) (*Flag, error) {
+	body := token[1:]
+	for i, char := range body {
+		name := string(char)
+		flag, ok := allFlagMap[name]
+		if !ok {
+			return nil, fmt.Errorf("unrecognized flag -%s in %s", name, token)
+		}
+
+		rest := body[i+len(name):]
+		if !flag.takesValue() {
+			if strings.HasPrefix(rest, "=") {
+				return nil, flag.PopulateMap(rest[1:], tokenMap)
+			}
+
+			err := flag.populatePresent(tokenMap)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// the remainder of the token is the value of a flag which takes one
+		rest = strings.TrimPrefix(rest, "=")
+		if rest == "" {
+			return flag, nil
+		}
+
+		return nil, flag.PopulateMap(rest, tokenMap)
+	}
+
+	return nil, nil
 }
 
 func (c *Command) getInvocation() string {
@@ -422,6 +810,45 @@ func (c *Command) getInvocation() string {
 	return strings.Join(parts, " ")
 }
 
+// shortFlagHint describes how the short flags may be combined, using the flags as examples, or returns an empty string
+// if there are no short flags to combine
+func shortFlagHint(flags []*Flag) string {
+	bools := ""
+	valued := ""
+	for _, flag := range flags {
+		if flag.ShortName == "" {
+			continue
+		}
+		if !flag.takesValue() {
+			bools += flag.ShortName
+		} else if valued == "" {
+			valued = flag.ShortName
+		}
+	}
+
+	examples := []string{}
+	if len(bools) >= 2 {
+		examples = append(examples, fmt.Sprintf("combined, ex. -%s", bools[:2]))
+	}
+	if valued != "" {
+		examples = append(examples, fmt.Sprintf("given attached values, ex. -%sVALUE or -%s=VALUE", valued, valued))
+	}
+	if len(examples) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("Short flags may be %s", strings.Join(examples, ", and "))
+}
+
+// withTypeLabel appends the label of the ArgType, if it has one, to the name of a flag or argument as shown in help
+func withTypeLabel(name string, argType ArgType) string {
+	if label := argType.label(); label != "" {
+		return fmt.Sprintf("%s %s", name, label)
+	}
+
+	return name
+}
+
 func (c *Command) GetHelpString(parentFlags []*Flag) string {
 	filteredFlags := []*Flag{}
 	for _, p := range parentFlags {
@@ -448,11 +875,12 @@ func (c *Command) GetHelpString(parentFlags []*Flag) string {
 			if arg.OneOf != nil {
 				oneOf := []string{}
 				for _, one := range arg.OneOf {
-					oneOf = append(oneOf, fmt.Sprintf("%s", one))
+					oneOf = append(oneOf, FormatValue(one))
 				}
 				description = append(description, fmt.Sprintf("one of %s", strings.Join(oneOf, ", ")))
 			}
-			lines = append(lines, fmt.Sprintf("  %s%s", PadRight(arg.Name, COMMAND_PADDING), strings.Join(description, " - ")))
+			description = append(description, arg.constraints().describe()...)
+			lines = append(lines, fmt.Sprintf("  %s%s", PadRight(withTypeLabel(arg.Name, arg.ArgType), COMMAND_PADDING), strings.Join(description, " - ")))
 		}
 	}
 
@@ -476,14 +904,32 @@ func (c *Command) GetHelpString(parentFlags []*Flag) string {
 			if flag.OneOf != nil {
 				oneOf := []string{}
 				for _, one := range flag.OneOf {
-					oneOf = append(oneOf, fmt.Sprintf("\"%s\"", one))
+					oneOf = append(oneOf, fmt.Sprintf("\"%s\"", FormatValue(one)))
 				}
 				description = append(description, fmt.Sprintf("one of %s", strings.Join(oneOf, ", ")))
 				if flag.DefaultValue != nil {
-					description = append(description, fmt.Sprintf("defaults to \"%s\"", flag.DefaultValue))
+					description = append(description, fmt.Sprintf("defaults to \"%s\"", FormatValue(flag.DefaultValue)))
 				}
 			}
-			lines = append(lines, fmt.Sprintf("  %s%s", PadRight(flag.GetPaddedInvocation(), COMMAND_PADDING), strings.Join(description, " - ")))
+			description = append(description, flag.constraints().describe()...)
+			if flag.EnvVar != "" {
+				description = append(description, fmt.Sprintf("env %s", flag.EnvVar))
+			}
+			if flag.ArgType == ArgTypeCount {
+				description = append(description, fmt.Sprintf("may be repeated, ex. %s", flag.repeatedInvocation()))
+			}
+			lines = append(lines, fmt.Sprintf("  %s%s", PadRight(withTypeLabel(flag.helpInvocation(), flag.ArgType), COMMAND_PADDING), strings.Join(description, " - ")))
+		}
+	}
+
+	if hint := shortFlagHint(append(append([]*Flag{}, filteredFlags...), c.Flags...)); hint != "" {
+		lines = append(lines, "", hint)
+	}
+
+	if groups := c.describeFlagGroups(); len(groups) > 0 {
+		lines = append(lines, "", "Flag constraints:")
+		for _, group := range groups {
+			lines = append(lines, fmt.Sprintf("  %s", group))
 		}
 	}
 
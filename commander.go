@@ -2,11 +2,15 @@ package commander
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	ns "github.com/hashibuto/nilshell"
 	"github.com/hashibuto/nilshell/pkg/termutils"
@@ -21,25 +25,83 @@ type Commander struct {
 
 	commandMap map[string]*Command
 	shell      *ns.Reader
+	streams    Streams
+	lastStatus atomic.Int32
+
+	variables    map[string]string
+	variableLock sync.RWMutex
+	aliases      map[string]string
+	aliasLock    sync.RWMutex
+
+	jobs    map[int]*Job
+	jobLock sync.Mutex
+
+	history  *History
+	defaults flagDefaults
+
+	transcriptLock sync.Mutex
 }
 
 type BoundExec struct {
 	IsCapturingOutput bool
+	IsHelp            bool
 	Command           *Command
+	ParentFlags       []*Flag
 	ArgMap            map[string]any
-}
+	Sources           ValueSources // the source of each value in ArgMap
 
-type Capture struct {
-	Buffer bytes.Buffer
-	Error  error
+	stderr      io.Writer // replaces the pipeline's stderr for this command only
+	mergeStderr bool      // stderr is written to the same destination as stdout
+	commandPath string    // the tokens which located the command, ex. "get process"
 }
 
-// NewCommander returns a new Commander instance
+// NewCommander returns a new Commander instance.  The builtin commands (help, grep, source, set, alias, jobs etc.) are
+// added to those of the config, except where the config defines a command of the same name, which then takes the place
+// of the builtin.  Defining any other command name more than once is an error.
 func NewCommander(config Config) (*Commander, error) {
-	config.Commands = append(config.Commands, HelpCommand, GrepCommand, ClearCommand, ExitCommand)
+	// each commander receives its own copy of the builtins, since they hold a reference to the commander they act upon
+	builtins := []*Command{HelpCommand, GrepCommand, SourceCommand, SetCommand, UnsetCommand, EnvCommand, AliasCommand, UnaliasCommand, HistoryCommand, JobsCommand, FgCommand, WaitCommand, KillCommand, ClearCommand, ExitCommand}
+	config.Commands = slices.Clip(config.Commands)
+	for _, builtin := range builtins {
+		if !slices.ContainsFunc(config.Commands, func(cmd *Command) bool { return cmd.Name == builtin.Name }) {
+			config.Commands = append(config.Commands, builtin.clone())
+		}
+	}
 
 	c := &Commander{
-		Config: config,
+		Config:    config,
+		variables: map[string]string{},
+		aliases:   map[string]string{},
+		jobs:      map[int]*Job{},
+		streams: Streams{
+			Stdin:  config.Stdin,
+			Stdout: config.Stdout,
+			Stderr: config.Stderr,
+		},
+	}
+
+	if c.streams.Stdout == nil {
+		c.streams.Stdout = os.Stdout
+	}
+	if c.streams.Stderr == nil {
+		c.streams.Stderr = os.Stderr
+	}
+
+	for name, value := range config.Aliases {
+		if !isAliasName(name) {
+			return nil, fmt.Errorf("invalid alias name \"%s\"", name)
+		}
+		c.aliases[name] = value
+	}
+
+	err := c.loadAliases()
+	if err != nil {
+		return nil, fmt.Errorf("unable to load aliases: %w", err)
+	}
+
+	c.history, err = NewHistory(config.HistoryFile, config.HistorySize, config.HistoryNamespace)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load history: %w", err)
 	}
 
 	commandMap := map[string]*Command{}
@@ -56,22 +118,42 @@ func NewCommander(config Config) (*Commander, error) {
 		commandMap[cmd.Name] = cmd
 
 		// Give everyone a reference to the commander, in order to carry out top level operations if necessary
-		cmd.Commander = c
-		for _, sub := range cmd.SubCommands {
-			sub.Commander = c
-		}
+		cmd.setCommander(c, cmd.Name)
 	}
 
 	c.commandMap = commandMap
+
+	c.defaults, err = loadDefaultsFile(config.DefaultsFile)
+	if err == nil {
+		err = c.validateDefaults()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to load flag defaults: %w", err)
+	}
 	c.shell = ns.NewReader(ns.ReaderConfig{
 		PromptFunction:     config.PromptFunc,
 		CompletionFunction: c.shellCompletionFunc,
 		ProcessFunction:    c.shellExecutionFunc,
+		HistoryManager:     c.history,
 	})
 
 	return c, nil
 }
 
+// LastStatus returns the exit status of the most recently executed command
+func (c *Commander) LastStatus() int {
+	return int(c.lastStatus.Load())
+}
+
+func (c *Commander) setLastStatus(status int) {
+	c.lastStatus.Store(int32(status))
+}
+
+// History returns the line history of the interactive shell
+func (c *Commander) History() *History {
+	return c.history
+}
+
 // LocateCommand will attempt to locate a command from a series of tokens presented as arguments to the Commander.
 // The method will match up to either the final subcommand, returning the remaining arguments, or to the final matching
 // subcommand, returning whatever unmatched is left.
@@ -112,18 +194,34 @@ func (c *Commander) LocateCommand(tokens []string) (*Command, []*Flag, []string)
 // suggestions for completion.
 func (c *Commander) shellCompletionFunc(beforeAndCursor string, afterCursor string, full string) *ns.Suggestions {
 	autoComplete := ns.NewSuggestions()
-	tokenGroups := Tokenize(beforeAndCursor)
+	// the line is most likely incomplete (ex. an unterminated quote), so any syntax error is disregarded
+	tokenGroups, _ := Tokenize(beforeAndCursor)
 	if len(tokenGroups) == 0 {
 		return nil
 	}
 
 	// we are only concerned with the last token group
-	tokens := tokenGroups[len(tokenGroups)-1].Tokens
-
-	if strings.HasSuffix(beforeAndCursor, " ") {
-		// this assists in finding the "next" thing, when there's no non-whitespace input
+	lastGroup := tokenGroups[len(tokenGroups)-1]
+	if lastGroup.IsRedirect() {
+		return nil
+	}
+	tokens := lastGroup.Tokens
+
+	// the token being completed is the one which ends at the cursor, otherwise it's the "next" token, which has no
+	// input as of yet
+	final := &Token{Offset: len(beforeAndCursor)}
+	if n := len(lastGroup.Words); n > 0 && lastGroup.Words[n-1].Offset+len(lastGroup.Words[n-1].Raw) == len(beforeAndCursor) {
+		final = lastGroup.Words[n-1]
+	} else {
 		tokens = append(tokens, "")
 	}
+
+	if !strings.HasPrefix(final.Raw, "'") {
+		if variableSuggestions := c.suggestVariables(final.Raw); variableSuggestions != nil {
+			return adaptSuggestions(final, variableSuggestions, false)
+		}
+	}
+
 	command, parentFlags, remaining := c.LocateCommand(tokens)
 	if len(remaining) == 0 {
 		return nil
@@ -137,7 +235,13 @@ func (c *Commander) shellCompletionFunc(beforeAndCursor string, afterCursor stri
 			}
 		}
 
-		return autoComplete
+		for _, name := range c.aliasNames() {
+			if _, isCommand := c.commandMap[name]; !isCommand && strings.HasPrefix(name, remaining[0]) {
+				autoComplete.Add(ns.NewSuggestion(name, name))
+			}
+		}
+
+		return adaptSuggestions(final, autoComplete, true)
 	}
 
 	suggestions := command.Suggest(remaining, parentFlags)
@@ -147,145 +251,551 @@ func (c *Commander) shellCompletionFunc(beforeAndCursor string, afterCursor stri
 		}
 	}
 
-	return autoComplete
+	return adaptSuggestions(final, autoComplete, true)
 }
 
+// adaptSuggestions adapts the value of each suggestion to the token being completed.  when requote is true, the value
+// is quoted in the same manner as the token, or escaped if necessary.  since the shell only replaces the text
+// following the final space before the cursor, the value is trimmed to that which follows the final space in the
+// token.
+func adaptSuggestions(final *Token, suggestions *ns.Suggestions, requote bool) *ns.Suggestions {
+	adapted := ns.NewSuggestions()
+	for _, suggestion := range suggestions.Items {
+		value := suggestion.Value
+		if final.Raw != final.Text || (requote && strings.ContainsAny(value, QUOTED_CHARS)) {
+			if requote {
+				value = quoteToken(value, final.Raw)
+			}
+
+			// a token which can't be extended to the suggested value is left as it is
+			if !strings.HasPrefix(value, final.Raw) {
+				continue
+			}
+
+			if idx := strings.LastIndexByte(final.Raw, ' '); idx != -1 {
+				value = value[idx+1:]
+			}
+		}
+		adapted.Add(ns.NewSuggestion(suggestion.Display, value))
+	}
+
+	return adapted
+}
+
+// bindCommand locates the command described by tokens and classifies its arguments, returning a BoundExec which is
+// ready to be executed.  If the tokens request contextual help, the returned BoundExec is marked as such.
+func (c *Commander) bindCommand(tokens []string) (*BoundExec, error) {
+	if len(tokens) == 0 {
+		return nil, usageError(fmt.Errorf("no command specified"), "")
+	}
+
+	command, parentFlags, remaining := c.LocateCommand(tokens)
+	if command == nil {
+		return nil, usageError(fmt.Errorf("unknown command \"%s\"", remaining[0]), "run \"help\" for a list of commands")
+	}
+
+	commandPath := strings.Join(tokens[:len(tokens)-len(remaining)], " ")
+	if slices.Contains(tokens, "--help") {
+		return &BoundExec{
+			Command:     command,
+			ParentFlags: parentFlags,
+			IsHelp:      true,
+			commandPath: commandPath,
+		}, nil
+	}
+
+	hint := fmt.Sprintf("run \"%s --help\" for usage", commandPath)
+
+	argMap, sources, err := command.classifyTokens(remaining, parentFlags)
+	if err != nil {
+		return nil, usageError(err, hint)
+	}
+
+	for _, arg := range command.Arguments {
+		if _, exists := argMap[arg.Name]; !exists {
+			return nil, usageError(fmt.Errorf("missing argument \"%s\"", arg.Name), hint)
+		}
+	}
+
+	if !command.IsExecutable() {
+		return nil, usageError(fmt.Errorf("please specify a valid subcommand"), hint)
+	}
+
+	return &BoundExec{
+		Command:     command,
+		ParentFlags: parentFlags,
+		ArgMap:      argMap,
+		Sources:     sources,
+		commandPath: commandPath,
+	}, nil
+}
+
+// shellExecutionFunc is invoked by the shell for each line of input.  errors are displayed to the user rather than
+// returned, since returning an error would terminate the shell.
 func (c *Commander) shellExecutionFunc(input string) error {
-	tokenGroups := Tokenize(input)
+	// the terminal belongs to the shell, so there is no input for the first command
+	streams := Streams{
+		Stdout: c.streams.Stdout,
+		Stderr: c.streams.Stderr,
+	}
+
+	// jobs which completed while the shell awaited input are reported ahead of the line's output
+	c.reportJobs(streams)
+
+	// history references are expanded before anything else, and the expanded line is shown to the user
+	expanded, err := c.history.expandLine(input)
+	if err != nil {
+		WriteError(c.streams.Stderr, err)
+		c.setLastStatus(EXIT_FAILURE)
+		return nil
+	}
+	if expanded != input {
+		Fprintln(c.streams.Stdout, expanded)
+	}
+
+	err = c.recordLine(context.Background(), expanded, streams, func(ctx context.Context, streams Streams) error {
+		err := c.executeLine(ctx, expanded, streams)
+		// jobs which completed while the line executed are reported before the prompt is drawn again
+		c.reportJobs(streams)
+		return err
+	})
+	if err == ns.ErrEof {
+		return err
+	}
+
+	if err != nil {
+		WriteError(c.streams.Stderr, err)
+	}
+
+	return nil
+}
+
+// andOrList is a list of pipelines joined by && and ||
+type andOrList struct {
+	pipelines    [][]*TokenGroup
+	sources      []string // source text of each pipeline, which is tokenized again immediately prior to its execution
+	start, end   int      // byte offsets of the list within the line
+	isBackground bool     // the list was terminated by &
+}
+
+// executeLine tokenizes and executes a single line of input, which is a list of pipelines separated by ;, &, && or ||.
+// the line is interrupted if the user presses ctrl+c while it executes.  errors from all but the final pipeline are
+// written to stderr, while the error of the final pipeline to have executed is returned.
+func (c *Commander) executeLine(ctx context.Context, input string, streams Streams) error {
+	input = c.expandAliases(input)
+	tokenGroups, err := Tokenize(input)
+	if err != nil {
+		err = syntaxUsageError(err)
+		c.setLastStatus(ExitCode(err))
+		return err
+	}
 	if len(tokenGroups) == 0 {
 		return nil
 	}
 
-	// make sure the groups make sense first
-	execSequence := []*BoundExec{}
-	for i, tokenGroup := range tokenGroups {
+	pipelines := [][]*TokenGroup{}
+	for _, tokenGroup := range tokenGroups {
+		if len(pipelines) == 0 || tokenGroup.IsListSeparator() {
+			pipelines = append(pipelines, []*TokenGroup{})
+		}
+		pipelines[len(pipelines)-1] = append(pipelines[len(pipelines)-1], tokenGroup)
+	}
 
-		if tokenGroup.FlowControl == FLOW_CONTROL_REDIRECT {
-			if i == 0 {
-				Errorln("nothing to redirect")
-				return nil
+	lists := []*andOrList{}
+	for i, pipeline := range pipelines {
+		if len(lists) == 0 || pipeline[0].IsListTerminator() {
+			if pipeline[0].FlowControl == FLOW_CONTROL_BACKGROUND {
+				lists[len(lists)-1].isBackground = true
 			}
+			lists = append(lists, &andOrList{})
+		}
 
-			if i != len(tokenGroups)-1 {
-				Errorln("redirect to file must be the final operation in the sequence")
-				return nil
-			}
+		start := pipeline[0].Offset + len(pipeline[0].FlowControl)
+		source := input[start:]
+		if i < len(pipelines)-1 {
+			source = input[start:pipelines[i+1][0].Offset]
+		}
 
-			if len(tokenGroup.Tokens) != 1 {
-				Errorln("redirect must specify a single file path target")
-				return nil
-			}
+		list := lists[len(lists)-1]
+		if len(list.pipelines) == 0 {
+			list.start = start
 		}
+		list.pipelines = append(list.pipelines, pipeline)
+		list.sources = append(list.sources, source)
+		list.end = start + len(source)
+	}
 
-		if tokenGroup.FlowControl == FLOW_CONTROL_PIPE || tokenGroup.FlowControl == FLOW_CONTROL_UNSPECIFIED {
-			isCapture := true
-			if i == len(tokenGroups)-1 {
-				isCapture = false
-			}
+	// a trailing ; or & is permitted, as it is in any posix shell
+	finalList := lists[len(lists)-1]
+	final := finalList.pipelines[0]
+	if len(lists) > 1 && len(finalList.pipelines) == 1 && len(final) == 1 && len(final[0].Tokens) == 0 && final[0].IsListTerminator() {
+		lists = lists[:len(lists)-1]
+	}
 
-			tokens := tokenGroup.Tokens
-			command, parentFlags, remaining := c.LocateCommand(tokens)
-			if command == nil {
-				Errorln(fmt.Sprintf("unknown command \"%s\"", remaining[0]))
-				return nil
-			}
+	// the group following each group, such that an operator which follows a missing command can be reported
+	following := map[*TokenGroup]*TokenGroup{}
+	for i := 1; i < len(tokenGroups); i++ {
+		following[tokenGroups[i-1]] = tokenGroups[i]
+	}
+	isOperator := func(group *TokenGroup) bool {
+		return group != nil && (group.IsListSeparator() || group.IsPipe())
+	}
 
-			isHelp := slices.Contains(tokens, "--help")
-			if isHelp {
-				fmt.Println(command.GetHelpString(parentFlags))
-				return nil
+	for _, list := range lists {
+		for _, pipeline := range list.pipelines {
+			for _, group := range pipeline {
+				if group.IsRedirect() || len(group.Tokens) != 0 {
+					continue
+				}
+
+				// the operator which is unexpected is the one that follows the missing command, unless the line ends
+				// without the command, in which case it's the operator left dangling
+				unexpected := following[group]
+				if !isOperator(unexpected) {
+					unexpected = group
+				}
+
+				if isOperator(unexpected) {
+					err = syntaxUsageError(&SyntaxError{
+						Line:    input,
+						Offset:  unexpected.Offset,
+						Message: fmt.Sprintf("unexpected \"%s\"", unexpected.FlowControl),
+					})
+				} else {
+					err = usageError(fmt.Errorf("no command specified"), "")
+				}
+				c.setLastStatus(ExitCode(err))
+				return err
 			}
+		}
+	}
+
+	ctx, stop := withInterrupt(ctx)
+	defer stop()
+
+	err = nil
+	for i, list := range lists {
+		if i > 0 && err != nil {
+			WriteError(streams.Stderr, err)
+		}
 
-			argMap, err := command.ClassifyTokens(remaining, parentFlags)
+		if list.isBackground {
+			list := list
+			err = c.checkBackground(list)
 			if err != nil {
-				Errorln(err.Error())
-				return nil
+				c.setLastStatus(ExitCode(err))
+				continue
 			}
 
-			for _, arg := range command.Arguments {
-				if _, exists := argMap[arg.Name]; !exists {
-					Errorln(fmt.Sprintf("missing argument \"%s\"", arg.Name))
+			line := strings.TrimSpace(input[list.start:list.end])
+			job := c.startJob(ctx, line, func(ctx context.Context, streams Streams) error {
+				err := c.executeList(ctx, list, streams)
+				if err == ns.ErrEof {
+					// exiting has no meaning within a background job
 					return nil
 				}
+				return err
+			})
+			Fprintln(streams.Stderr, fmt.Sprintf("[%d] %s", job.Id, line))
+			err = nil
+			c.setLastStatus(EXIT_SUCCESS)
+			continue
+		}
+
+		err = c.executeList(ctx, list, streams)
+		if err == ns.ErrEof || errors.Is(err, ErrInterrupted) {
+			return err
+		}
+	}
+
+	return err
+}
+
+// checkBackground returns a usage error if a command of the list is unable to run in the background, as is the case
+// with the legacy handlers, which write to the process' stdout
+func (c *Commander) checkBackground(list *andOrList) error {
+	for _, pipeline := range list.pipelines {
+		for _, tokenGroup := range pipeline {
+			if tokenGroup.IsRedirect() {
+				continue
 			}
 
-			execSequence = append(execSequence, &BoundExec{
-				IsCapturingOutput: isCapture,
-				Command:           command,
-				ArgMap:            argMap,
-			})
+			command, _, _ := c.LocateCommand(tokenGroup.Tokens)
+			if command != nil && command.isBuffered() {
+				return usageError(fmt.Errorf("command \"%s\" writes to the process' stdout, and cannot run in the background", command.Name), "")
+			}
 		}
 	}
 
-	capturedBytes := []byte{}
-	for _, bindExec := range execSequence {
-		// capture each command's stdout and pass to the input of the next
+	return nil
+}
 
-		if bindExec.IsCapturingOutput {
-			formerStdout := os.Stdout
-			pipeRead, pipeWrite, err := os.Pipe()
+// executeList executes each pipeline of the list, subject to the && and || operators joining them.  errors from all
+// but the final pipeline are written to stderr, while the error of the final pipeline to have executed is returned.
+func (c *Commander) executeList(ctx context.Context, list *andOrList, streams Streams) error {
+	var err error
+	for i, pipeline := range list.pipelines {
+		switch pipeline[0].FlowControl {
+		case FLOW_CONTROL_AND:
 			if err != nil {
-				Errorln(err.Error())
-				return nil
+				continue
 			}
+		case FLOW_CONTROL_OR:
+			if err == nil {
+				continue
+			}
+		}
 
-			os.Stdout = pipeWrite
-			completionChan := make(chan *Capture, 1)
+		if i > 0 && err != nil {
+			WriteError(streams.Stderr, err)
+		}
 
-			go func() {
-				capture := &Capture{}
-				_, err := io.Copy(&capture.Buffer, pipeRead)
-				if err != nil {
-					capture.Error = err
-				}
-				completionChan <- capture
-			}()
+		// each pipeline is tokenized again prior to execution, so that parameters reflect the preceding pipelines
+		var tokenGroups []*TokenGroup
+		tokenGroups, err = TokenizeAndExpand(list.sources[i], c.expand, c.substituter(ctx, streams))
+		if err == nil {
+			err = c.executePipeline(ctx, tokenGroups, streams)
+		}
+		if !isBackground(ctx) {
+			c.setLastStatus(ExitCode(err))
+		}
+		if err == ns.ErrEof || errors.Is(err, ErrInterrupted) {
+			return err
+		}
+	}
+
+	return err
+}
 
-			err = bindExec.Command.OnExecute(bindExec.Command, bindExec.ArgMap, capturedBytes)
-			pipeWrite.Close()
+// substituter returns a Substituter which executes the command line of a command substitution as it would any other
+// line, capturing its output with any terminal escape sequences removed
+func (c *Commander) substituter(ctx context.Context, streams Streams) Substituter {
+	return func(line string) (string, error) {
+		output := &bytes.Buffer{}
+		err := c.executeLine(ctx, line, Streams{
+			Stdout: output,
+			Stderr: streams.Stderr,
+		})
+		if err != nil && err != ns.ErrEof {
+			return "", fmt.Errorf("command substitution \"$(%s)\" failed: %w", line, err)
+		}
 
-			capture := <-completionChan
-			os.Stdout = formerStdout
+		return string(termutils.StripTerminalEscapeSequences(output.Bytes())), nil
+	}
+}
 
+// executePipeline binds and executes a single pipeline, including any redirects
+func (c *Commander) executePipeline(ctx context.Context, tokenGroups []*TokenGroup, streams Streams) error {
+	// make sure the groups make sense first
+	execSequence := []*BoundExec{}
+	var inputRedirect *TokenGroup
+	var outputRedirect *TokenGroup
+	stderrRedirects := map[*BoundExec]*TokenGroup{}
+	for _, tokenGroup := range tokenGroups {
+		if !tokenGroup.IsRedirect() {
+			if outputRedirect != nil {
+				return fmt.Errorf("redirect to file must be the final operation in the sequence")
+			}
+
+			bindExec, err := c.bindCommand(tokenGroup.Tokens)
 			if err != nil {
-				Errorln(err.Error())
-				return nil
+				return err
 			}
+			recordCommand(ctx, bindExec.commandPath)
 
-			if capture.Error != nil {
-				Errorln(err.Error())
+			if bindExec.IsHelp {
+				fmt.Fprintln(streams.Stdout, bindExec.Command.GetHelpString(bindExec.ParentFlags))
 				return nil
 			}
 
-			// We don't capture terminal codes
-			capturedBytes = termutils.StripTerminalEscapeSequences(capture.Buffer.Bytes())
+			// legacy handlers write to the process' stdout, which can't be shared with the foreground
+			if isBackground(ctx) && bindExec.Command.isBuffered() {
+				return fmt.Errorf("command \"%s\" writes to the process' stdout, and cannot run in the background", bindExec.Command.Name)
+			}
+
+			if len(execSequence) > 0 {
+				prevExec := execSequence[len(execSequence)-1]
+				prevExec.IsCapturingOutput = true
+				if tokenGroup.FlowControl == FLOW_CONTROL_PIPE_ALL {
+					if _, exists := stderrRedirects[prevExec]; exists {
+						return fmt.Errorf("stderr may only be redirected once per command")
+					}
+					prevExec.mergeStderr = true
+				}
+			}
+
+			execSequence = append(execSequence, bindExec)
 			continue
 		}
 
-		if bindExec.Command.OnExecute == nil {
-			Errorln("please specify a valid subcommand")
-			return nil
+		if len(execSequence) == 0 {
+			return fmt.Errorf("nothing to redirect")
+		}
+
+		if len(tokenGroup.Tokens) != 1 {
+			return fmt.Errorf("redirect must specify a single file path target")
 		}
-		err := bindExec.Command.OnExecute(bindExec.Command, bindExec.ArgMap, capturedBytes)
+
+		curExec := execSequence[len(execSequence)-1]
+		switch tokenGroup.FlowControl {
+		case FLOW_CONTROL_INPUT:
+			if len(execSequence) != 1 {
+				return fmt.Errorf("input redirect must apply to the first command in the sequence")
+			}
+			if inputRedirect != nil {
+				return fmt.Errorf("input may only be redirected once")
+			}
+			inputRedirect = tokenGroup
+		case FLOW_CONTROL_REDIRECT_STDERR:
+			if _, exists := stderrRedirects[curExec]; exists || curExec.mergeStderr {
+				return fmt.Errorf("stderr may only be redirected once per command")
+			}
+			stderrRedirects[curExec] = tokenGroup
+		default:
+			if outputRedirect != nil {
+				return fmt.Errorf("output may only be redirected once")
+			}
+			if tokenGroup.FlowControl == FLOW_CONTROL_REDIRECT_ALL {
+				if _, exists := stderrRedirects[curExec]; exists {
+					return fmt.Errorf("stderr may only be redirected once per command")
+				}
+				curExec.mergeStderr = true
+			}
+			outputRedirect = tokenGroup
+		}
+	}
+
+	files := []io.Closer{}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	if inputRedirect != nil {
+		target := inputRedirect.Tokens[0]
+		f, err := os.Open(target)
 		if err != nil {
-			Errorln(err.Error())
-			return nil
+			return fmt.Errorf("unable to read from file %s: %w", target, err)
 		}
+		files = append(files, f)
+		streams.Stdin = f
 	}
 
-	finalTokenGroup := tokenGroups[len(tokenGroups)-1]
-	if finalTokenGroup.FlowControl == FLOW_CONTROL_REDIRECT {
-		target := finalTokenGroup.Tokens[0]
-		err := os.WriteFile(target, capturedBytes, 0644)
+	// we don't write terminal codes to files
+	var outputFile io.WriteCloser
+	if outputRedirect != nil {
+		target := outputRedirect.Tokens[0]
+		f, err := openRedirectTarget(outputRedirect)
 		if err != nil {
-			Errorln("unable to write to file ", target, ": ", err.Error())
-			return nil
+			return fmt.Errorf("unable to write to file %s: %w", target, err)
 		}
+		outputFile = newEscapeStripper(f)
+		streams.Stdout = outputFile
 	}
 
-	return nil
+	for bindExec, stderrRedirect := range stderrRedirects {
+		target := stderrRedirect.Tokens[0]
+		f, err := openRedirectTarget(stderrRedirect)
+		if err != nil {
+			if outputFile != nil {
+				outputFile.Close()
+			}
+			return fmt.Errorf("unable to write to file %s: %w", target, err)
+		}
+		stderrFile := newEscapeStripper(f)
+		files = append(files, stderrFile)
+		bindExec.stderr = stderrFile
+	}
+
+	err := runPipeline(ctx, execSequence, streams)
+	if outputFile != nil {
+		// closing flushes any remaining output, so the error is of interest
+		closeErr := outputFile.Close()
+		if err == nil && closeErr != nil {
+			return fmt.Errorf("unable to write to file %s: %w", outputRedirect.Tokens[0], closeErr)
+		}
+	}
+
+	return err
+}
+
+// openRedirectTarget opens the file targeted by an output redirect
+func openRedirectTarget(redirect *TokenGroup) (*os.File, error) {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if redirect.FlowControl == FLOW_CONTROL_APPEND {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+
+	return os.OpenFile(redirect.Tokens[0], flags, 0644)
 }
 
+// Execute runs a single command, described by args, without starting the interactive shell.  The args are treated as
+// already tokenized (as is the case with os.Args), thus pipes and redirects are not interpreted.  The exit status of
+// the command can be obtained from the returned error using ExitCode.
+func (c *Commander) Execute(args []string) error {
+	// the program's own --help, or the absence of a command, displays the list of commands
+	if len(args) == 0 || args[0] == "--help" {
+		args = []string{HelpCommand.Name}
+	}
+
+	// the args are recorded as a line which would produce the same tokens, so that the transcript can be replayed
+	quoted := []string{}
+	for _, arg := range args {
+		quoted = append(quoted, quoteToken(arg, ""))
+	}
+
+	err := c.recordLine(context.Background(), strings.Join(quoted, " "), c.streams, func(ctx context.Context, streams Streams) error {
+		return c.executeArgs(ctx, args, streams)
+	})
+	c.setLastStatus(ExitCode(err))
+	return err
+}
+
+func (c *Commander) executeArgs(ctx context.Context, args []string, streams Streams) error {
+	args, err := c.expandArgAlias(args)
+	if err != nil {
+		return usageError(err, "")
+	}
+
+	bindExec, err := c.bindCommand(args)
+	if err != nil {
+		return err
+	}
+	recordCommand(ctx, bindExec.commandPath)
+
+	if bindExec.IsHelp {
+		fmt.Fprintln(streams.Stdout, bindExec.Command.GetHelpString(bindExec.ParentFlags))
+		return nil
+	}
+
+	// ctrl+c is left to terminate the process, unless the command is able to stop when its context is cancelled
+	if bindExec.Command.isCancellable() {
+		var stop func()
+		ctx, stop = withInterrupt(ctx)
+		defer stop()
+	}
+
+	err = runPipeline(ctx, []*BoundExec{bindExec}, streams)
+	if err == ns.ErrEof {
+		// exiting has no meaning outside of the interactive shell
+		return nil
+	}
+
+	return err
+}
+
+// RunArgs executes the command described by the process arguments (os.Args), without starting the interactive shell
+func (c *Commander) RunArgs() error {
+	return c.Execute(os.Args[1:])
+}
+
+// Run executes the command described by the process arguments if any were provided, otherwise it starts the
+// interactive shell and blocks until it exits.
 func (c *Commander) Run() error {
+	if len(os.Args) > 1 {
+		return c.RunArgs()
+	}
+
 	return c.shell.ReadLoop()
 }
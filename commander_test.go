@@ -1,9 +1,24 @@
 package commander
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
 	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	ns "github.com/hashibuto/nilshell"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 )
@@ -120,6 +135,1661 @@ func (suite *CommanderTestSuite) TestLocateDeepCommand() {
 	assert.NotNil(suite.T(), command)
 }
 
+func (suite *CommanderTestSuite) TestExecute() {
+	err := suite.TheCommander.Execute([]string{"farm", "add", "-t", "mammal"})
+	assert.NoError(suite.T(), err)
+}
+
+func (suite *CommanderTestSuite) TestExecuteUnknownCommand() {
+	err := suite.TheCommander.Execute([]string{"barn"})
+	assert.EqualError(suite.T(), err, "unknown command \"barn\"")
+}
+
+func (suite *CommanderTestSuite) TestExecuteMissingRequiredFlag() {
+	err := suite.TheCommander.Execute([]string{"farm", "add"})
+	assert.Error(suite.T(), err)
+	assert.Equal(suite.T(), EXIT_USAGE, ExitCode(err))
+	assert.Equal(suite.T(), EXIT_USAGE, suite.TheCommander.LastStatus())
+}
+
+func (suite *CommanderTestSuite) TestExecuteMissingSubcommand() {
+	err := suite.TheCommander.Execute([]string{"farm", "snapshot"})
+	assert.EqualError(suite.T(), err, "please specify a valid subcommand")
+}
+
+func (suite *CommanderTestSuite) TestRunScript() {
+	script := strings.Join([]string{
+		"# add an animal",
+		"",
+		"farm add \\",
+		"  -t mammal",
+		"barn",
+		"farm inventory",
+	}, "\n")
+
+	err := suite.TheCommander.RunScript(strings.NewReader(script), ScriptConfig{Name: "farm.cmd", StopOnError: true})
+	var scriptErr *ScriptError
+	assert.ErrorAs(suite.T(), err, &scriptErr)
+	assert.Equal(suite.T(), 5, scriptErr.Line)
+	assert.EqualError(suite.T(), err, "farm.cmd:5: unknown command \"barn\"")
+}
+
+func TestCommander_Timeout(t *testing.T) {
+	c, err := NewCommander(Config{
+		Commands: []*Command{
+			{
+				Name:    "wait",
+				Timeout: 10 * time.Millisecond,
+				OnExecuteContext: func(ctx context.Context, c *Command, args ArgMap, capturedInput []byte) error {
+					<-ctx.Done()
+					return ctx.Err()
+				},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	err = c.Execute([]string{"wait"})
+	assert.EqualError(t, err, "command \"wait\" timed out after 10ms")
+}
+
+func TestCommander_PipelineCancelsUpstream(t *testing.T) {
+	c, err := NewCommander(Config{
+		Commands: []*Command{
+			{
+				Name: "produce",
+				OnStream: func(ex *Execution) error {
+					for {
+						_, err := fmt.Fprintln(ex.Stdout, "line")
+						if err != nil {
+							return err
+						}
+					}
+				},
+			},
+			{
+				Name: "take",
+				OnStream: func(ex *Execution) error {
+					line, err := bufio.NewReader(ex.Stdin).ReadString('\n')
+					if err != nil {
+						return err
+					}
+					if line != "line\n" {
+						return fmt.Errorf("unexpected input %q", line)
+					}
+					return nil
+				},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	err = c.executeLine(context.Background(), "produce | take", Streams{Stdout: io.Discard, Stderr: io.Discard})
+	assert.NoError(t, err)
+}
+
+func TestCommander_ConfiguredStreams(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	c, err := NewCommander(Config{
+		Stdin:  strings.NewReader("cow\nhorse\n"),
+		Stdout: stdout,
+		Stderr: io.Discard,
+		Commands: []*Command{
+			{
+				Name: "echo",
+				OnExecute: func(c *Command, args ArgMap, capturedInput []byte) error {
+					fmt.Print(string(capturedInput))
+					return nil
+				},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	err = c.Execute([]string{"echo"})
+	assert.NoError(t, err)
+	assert.Equal(t, "cow\nhorse\n", stdout.String())
+}
+
+func TestCommander_UnconfiguredStdin(t *testing.T) {
+	// data waiting on the process' stdin is left alone unless it is configured as the input
+	pipeRead, pipeWrite, err := os.Pipe()
+	assert.NoError(t, err)
+	defer pipeRead.Close()
+	_, err = pipeWrite.WriteString("cow\n")
+	assert.NoError(t, err)
+	pipeWrite.Close()
+
+	stdin := os.Stdin
+	os.Stdin = pipeRead
+	defer func() { os.Stdin = stdin }()
+
+	var input []byte
+	c, err := NewCommander(Config{
+		Stdout: io.Discard,
+		Stderr: io.Discard,
+		Commands: []*Command{
+			{
+				Name: "echo",
+				OnExecute: func(c *Command, args ArgMap, capturedInput []byte) error {
+					input = capturedInput
+					return nil
+				},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	err = c.Execute([]string{"echo"})
+	assert.NoError(t, err)
+	assert.Empty(t, input)
+}
+
+func TestCommander_TopLevelHelp(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	c, err := NewCommander(Config{
+		Stdout: stdout,
+		Commands: []*Command{
+			{
+				Name:        "get",
+				Description: "get a resource",
+				OnStream: func(ex *Execution) error {
+					return nil
+				},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	err = c.Execute([]string{"help"})
+	assert.NoError(t, err)
+	help := stdout.String()
+	assert.Contains(t, help, "get a resource")
+
+	for _, args := range [][]string{{"--help"}, nil} {
+		stdout.Reset()
+		err = c.Execute(args)
+		assert.NoError(t, err, args)
+		assert.Equal(t, EXIT_SUCCESS, c.LastStatus())
+		assert.Equal(t, help, stdout.String(), args)
+	}
+}
+
+func TestCommander_GrepLongLines(t *testing.T) {
+	long := strings.Repeat("hay", 70000/3) + "needle"
+	stdout := &bytes.Buffer{}
+	c, err := NewCommander(Config{
+		Stdin:  strings.NewReader("needle\nhay\n" + long),
+		Stdout: stdout,
+		Stderr: io.Discard,
+	})
+	assert.NoError(t, err)
+
+	err = c.Execute([]string{"grep", "needle"})
+	assert.NoError(t, err)
+	assert.Equal(t, "needle\n"+long+"\n", stdout.String())
+}
+
+func TestCommander_Redirects(t *testing.T) {
+	c, err := NewCommander(Config{
+		Stdout: io.Discard,
+		Stderr: io.Discard,
+		Commands: []*Command{
+			{
+				Name: "echo",
+				OnStream: func(ex *Execution) error {
+					_, err := io.Copy(ex.Stdout, ex.Stdin)
+					ex.Errorln("done")
+					return err
+				},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.txt")
+	output := filepath.Join(dir, "out.txt")
+	errOutput := filepath.Join(dir, "err.txt")
+	assert.NoError(t, os.WriteFile(input, []byte("cow\n"), 0644))
+
+	streams := Streams{Stdout: io.Discard, Stderr: io.Discard}
+	for i := 0; i < 2; i++ {
+		err = c.executeLine(context.Background(), fmt.Sprintf("echo < %s | grep o >> %s 2> %s", input, output, errOutput), streams)
+		assert.NoError(t, err)
+	}
+
+	data, err := os.ReadFile(output)
+	assert.NoError(t, err)
+	assert.Equal(t, "cow\ncow\n", string(data))
+
+	// stderr of the first command was not redirected
+	data, err = os.ReadFile(errOutput)
+	assert.NoError(t, err)
+	assert.Equal(t, "", string(data))
+
+	err = c.executeLine(context.Background(), fmt.Sprintf("echo < %s &> %s", input, output), streams)
+	assert.NoError(t, err)
+	data, err = os.ReadFile(output)
+	assert.NoError(t, err)
+	assert.Equal(t, "cow\ndone\n", string(data))
+
+	err = c.executeLine(context.Background(), fmt.Sprintf("echo | echo < %s", input), streams)
+	assert.EqualError(t, err, "input redirect must apply to the first command in the sequence")
+
+	// the output file is closed when the stderr target can't be opened
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("open files can't be counted on this platform")
+	}
+	missing := filepath.Join(dir, "missing", "err.txt")
+	err = c.executeLine(context.Background(), fmt.Sprintf("echo < %s > %s 2> %s", input, output, missing), streams)
+	assert.ErrorContains(t, err, "unable to write to file "+missing)
+	after, err := os.ReadDir("/proc/self/fd")
+	assert.NoError(t, err)
+	assert.Len(t, after, len(fds))
+}
+
+func TestCommander_CommandLists(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	c, err := NewCommander(Config{
+		Commands: []*Command{
+			{
+				Name:      "say",
+				Arguments: []*Argument{{Name: "text"}},
+				OnStream: func(ex *Execution) error {
+					_, err := fmt.Fprintln(ex.Stdout, ex.Args.GetString("text"))
+					return err
+				},
+			},
+			{
+				Name: "fail",
+				OnStream: func(ex *Execution) error {
+					return fmt.Errorf("failed")
+				},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	streams := Streams{Stdout: stdout, Stderr: io.Discard}
+	testCases := []struct {
+		line   string
+		output string
+		err    string
+	}{
+		{"say a ; say b", "a\nb\n", ""},
+		{"fail ; say b", "b\n", ""},
+		{"say a && say b", "a\nb\n", ""},
+		{"fail && say b", "", "failed"},
+		{"fail || say b", "b\n", ""},
+		{"say a || say b", "a\n", ""},
+		{"fail && say a || say b", "b\n", ""},
+		{"say a || say b && say c", "a\nc\n", ""},
+		{"say a | grep a && say b;", "a\nb\n", ""},
+		{"say a && ", "", "syntax error at column 7: unexpected \"&&\""},
+		{"say a ; ;", "", "syntax error at column 9: unexpected \";\""},
+		{"say a && ; say b", "", "syntax error at column 10: unexpected \";\""},
+		{"say a || && say b", "", "syntax error at column 10: unexpected \"&&\""},
+		{"; say a", "", "syntax error at column 1: unexpected \";\""},
+		{"say a ; && say b", "", "syntax error at column 9: unexpected \"&&\""},
+		{"say a & ; say b", "", "syntax error at column 9: unexpected \";\""},
+		{"say a |", "", "syntax error at column 7: unexpected \"|\""},
+		{"| say a", "", "syntax error at column 1: unexpected \"|\""},
+		{"say a |& ; say b", "", "syntax error at column 10: unexpected \";\""},
+		{"say a | | grep a", "", "syntax error at column 9: unexpected \"|\""},
+		{"say a ; |& grep a", "", "syntax error at column 9: unexpected \"|&\""},
+		{"|| say a", "", "syntax error at column 1: unexpected \"||\""},
+		{"fail ; say $?", "1\n", ""},
+		{"say a ; say '$?'", "a\n$?\n", ""},
+		{"unknown || say $?", "2\n", ""},
+		{"set target=db01 ; say $target", "db01\n", ""},
+		{"unset target ; say a$target", "a\n", ""},
+	}
+
+	for _, testCase := range testCases {
+		stdout.Reset()
+		err := c.executeLine(context.Background(), testCase.line, streams)
+		if testCase.err == "" {
+			assert.NoError(t, err, testCase.line)
+		} else {
+			assert.EqualError(t, err, testCase.err, testCase.line)
+		}
+		assert.Equal(t, testCase.output, stdout.String(), testCase.line)
+	}
+}
+
+func TestCommander_Variables(t *testing.T) {
+	t.Setenv("COMMANDER_REGION", "north")
+	c, err := NewCommander(Config{
+		ExpandEnv: true,
+		Commands: []*Command{
+			{
+				Name:      "say",
+				Arguments: []*Argument{{Name: "text"}},
+				OnStream: func(ex *Execution) error {
+					_, err := fmt.Fprintln(ex.Stdout, ex.Args.GetString("text"))
+					return err
+				},
+			},
+		},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, c.SetVariable("target", "db01"))
+	assert.Error(t, c.SetVariable("1target", "db01"))
+
+	value, ok := c.GetVariable("COMMANDER_REGION")
+	assert.True(t, ok)
+	assert.Equal(t, "north", value)
+
+	suggestions := c.shellCompletionFunc("say $ta", "", "say $ta")
+	assert.Len(t, suggestions.Items, 1)
+	assert.Equal(t, "$target", suggestions.Items[0].Value)
+
+	suggestions = c.shellCompletionFunc("say x${COMMANDER_R", "", "say x${COMMANDER_R")
+	assert.Len(t, suggestions.Items, 1)
+	assert.Equal(t, "x${COMMANDER_REGION}", suggestions.Items[0].Value)
+}
+
+func TestCommander_MultipleCommanders(t *testing.T) {
+	// each commander's builtins act upon that commander, and are wrapped only by its own middleware
+	newCommander := func(audit *[]string) *Commander {
+		c, err := NewCommander(Config{
+			Stdout: io.Discard,
+			Stderr: io.Discard,
+			Middleware: []Middleware{
+				func(next Handler) Handler {
+					return func(ex *Execution) error {
+						*audit = append(*audit, ex.Path)
+						return next(ex)
+					}
+				},
+			},
+		})
+		assert.NoError(t, err)
+		return c
+	}
+
+	auditA := []string{}
+	auditB := []string{}
+	a := newCommander(&auditA)
+	b := newCommander(&auditB)
+
+	assert.NoError(t, a.executeLine(context.Background(), "set x=1", Streams{Stdout: io.Discard, Stderr: io.Discard}))
+	assert.NoError(t, b.executeLine(context.Background(), "alias hi=help", Streams{Stdout: io.Discard, Stderr: io.Discard}))
+
+	value, ok := a.GetVariable("x")
+	assert.True(t, ok)
+	assert.Equal(t, "1", value)
+	_, ok = b.GetVariable("x")
+	assert.False(t, ok)
+
+	_, ok = a.GetAlias("hi")
+	assert.False(t, ok)
+	_, ok = b.GetAlias("hi")
+	assert.True(t, ok)
+
+	assert.Equal(t, []string{"set"}, auditA)
+	assert.Equal(t, []string{"alias"}, auditB)
+}
+
+func TestCommander_BuiltinOverride(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	c, err := NewCommander(Config{
+		Commands: []*Command{
+			{
+				Name: "help",
+				OnStream: func(ex *Execution) error {
+					_, err := fmt.Fprintln(ex.Stdout, "custom help")
+					return err
+				},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	err = c.executeLine(context.Background(), "help", Streams{Stdout: stdout, Stderr: io.Discard})
+	assert.NoError(t, err)
+	assert.Equal(t, "custom help\n", stdout.String())
+
+	// the remaining builtins are unaffected
+	_, ok := c.commandMap["set"]
+	assert.True(t, ok)
+
+	// names other than those of builtins may not be repeated
+	_, err = NewCommander(Config{
+		Commands: []*Command{
+			{Name: "say", OnStream: func(ex *Execution) error { return nil }},
+			{Name: "say", OnStream: func(ex *Execution) error { return nil }},
+		},
+	})
+	assert.EqualError(t, err, "command \"say\" is defined multiple times")
+}
+
+func TestCommander_Substitution(t *testing.T) {
+	c, err := NewCommander(Config{
+		Commands: []*Command{
+			{
+				Name:      "say",
+				Arguments: []*Argument{{Name: "text", AllowMultiple: true}},
+				OnStream: func(ex *Execution) error {
+					_, err := fmt.Fprintln(ex.Stdout, strings.Join(ex.Args.GetStringArray("text"), ","))
+					return err
+				},
+			},
+			{
+				Name: "fail",
+				OnStream: func(ex *Execution) error {
+					return NewExitError(3, fmt.Errorf("failed"))
+				},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	stdout := &bytes.Buffer{}
+	streams := Streams{Stdout: stdout, Stderr: io.Discard}
+	err = c.executeLine(context.Background(), `say $(say "db01 db02" | grep db) "$(say $(say a b))"`, streams)
+	assert.NoError(t, err)
+	assert.Equal(t, "db01,db02,a,b\n", stdout.String())
+
+	stdout.Reset()
+	err = c.executeLine(context.Background(), "say $(fail) || say recovered", streams)
+	assert.NoError(t, err)
+	assert.Equal(t, "recovered\n", stdout.String())
+
+	err = c.executeLine(context.Background(), "say $(fail)", streams)
+	assert.EqualError(t, err, `command substitution "$(fail)" failed: failed`)
+	assert.Equal(t, 3, ExitCode(err))
+}
+
+func TestCommander_CompleteQuoted(t *testing.T) {
+	c, err := NewCommander(Config{
+		Commands: []*Command{
+			{
+				Name: "open",
+				Flags: []*Flag{
+					{
+						Name:    "file",
+						ArgType: ArgTypeString,
+						OneOf:   []any{"my file.txt", "notes.txt"},
+					},
+				},
+				OnStream: func(ex *Execution) error {
+					return nil
+				},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	cases := []struct {
+		line  string
+		value string
+	}{
+		{`open --file "my f`, `file.txt"`},
+		{`open --file 'my`, `'my file.txt'`},
+		{`open --file my\ f`, `file.txt`},
+		{`open --file n`, `notes.txt`},
+		{`open --file m`, `my\ file.txt`},
+	}
+
+	for _, testCase := range cases {
+		suggestions := c.shellCompletionFunc(testCase.line, "", testCase.line)
+		if assert.Len(t, suggestions.Items, 1, testCase.line) {
+			assert.Equal(t, testCase.value, suggestions.Items[0].Value, testCase.line)
+		}
+	}
+}
+
+func TestCommander_History(t *testing.T) {
+	historyFile := filepath.Join(t.TempDir(), "history")
+	stdout := &bytes.Buffer{}
+	newCommander := func(namespace string) *Commander {
+		c, err := NewCommander(Config{
+			HistoryFile:      historyFile,
+			HistorySize:      3,
+			HistoryNamespace: namespace,
+			Stdout:           stdout,
+			Stderr:           io.Discard,
+			Commands: []*Command{
+				{
+					Name:      "say",
+					Arguments: []*Argument{{Name: "text", AllowMultiple: true}},
+					OnStream: func(ex *Execution) error {
+						_, err := fmt.Fprintln(ex.Stdout, strings.Join(ex.Args.GetStringArray("text"), " "))
+						return err
+					},
+				},
+			},
+		})
+		assert.NoError(t, err)
+		return c
+	}
+
+	// lines are run as the shell would, and then pushed to the history
+	enter := func(c *Commander, line string) {
+		assert.NoError(t, c.shellExecutionFunc(line))
+		c.History().Push(line)
+	}
+
+	c := newCommander("app")
+	other := newCommander("other")
+	enter(other, "say other")
+	enter(c, "say one")
+	enter(c, "say two")
+
+	stdout.Reset()
+	enter(c, "!! '!!' \\!!")
+	assert.Equal(t, "say two '!!' \\!!"+C_RESET+"\ntwo !! !!\n", stdout.String())
+
+	stdout.Reset()
+	enter(c, "!1 again")
+	assert.Equal(t, "say one again"+C_RESET+"\none again\n", stdout.String())
+
+	expanded, err := c.History().Expand("!say t")
+	assert.NoError(t, err)
+	assert.Equal(t, "say one again t", expanded)
+
+	_, err = c.History().Expand("!missing")
+	assert.EqualError(t, err, "!missing: event not found")
+
+	// the history is restored from the file, up to the configured size, and separately for each namespace
+	assert.Equal(t, []string{"say other"}, newCommander("other").History().Entries())
+	c = newCommander("app")
+	assert.Equal(t, []string{"say two", "say two '!!' \\!!", "say one again"}, c.History().Entries())
+	assert.Equal(t, []string{"say one again"}, c.History().Search("again"))
+
+	stdout.Reset()
+	enter(c, "history -s again")
+	assert.Equal(t, "    3  say one again\n", stdout.String())
+
+	enter(c, "history --clear")
+	assert.Equal(t, []string{"say other"}, newCommander("other").History().Entries())
+	assert.Equal(t, []string{"history --clear"}, newCommander("app").History().Entries())
+}
+
+func TestCommander_Transcript(t *testing.T) {
+	dumpFile := filepath.Join(t.TempDir(), "transcript")
+	greeting := "hello"
+	c, err := NewCommander(Config{
+		DumpFile:   dumpFile,
+		DumpOutput: true,
+		Stdout:     io.Discard,
+		Stderr:     io.Discard,
+		Commands: []*Command{
+			{
+				Name:      "say",
+				Arguments: []*Argument{{Name: "text", AllowMultiple: true}},
+				OnStream: func(ex *Execution) error {
+					_, err := fmt.Fprintln(ex.Stdout, greeting, strings.Join(ex.Args.GetStringArray("text"), " "))
+					return err
+				},
+			},
+			{
+				Name: "fail",
+				OnStream: func(ex *Execution) error {
+					return NewExitError(3, fmt.Errorf("failed"))
+				},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, c.shellExecutionFunc("say a | grep hello"))
+	assert.NoError(t, c.shellExecutionFunc("fail || say b"))
+	assert.NoError(t, c.shellExecutionFunc("fail"))
+	assert.NoError(t, c.Execute([]string{"say", "c d"}))
+
+	data, err := os.ReadFile(dumpFile)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Len(t, lines, 4)
+
+	entry := &TranscriptEntry{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), entry))
+	assert.Equal(t, "fail || say b", entry.Input)
+	assert.Equal(t, []string{"fail", "say"}, entry.Commands)
+	assert.Equal(t, EXIT_SUCCESS, entry.Status)
+	assert.Equal(t, "failed\nhello b\n", *entry.Output)
+
+	assert.NoError(t, json.Unmarshal([]byte(lines[2]), entry))
+	assert.Equal(t, 3, entry.Status)
+	assert.Equal(t, "failed", entry.Error)
+
+	assert.NoError(t, json.Unmarshal([]byte(lines[3]), entry))
+	assert.Equal(t, `say c\ d`, entry.Input)
+
+	// replaying the transcript produces the same results, until the behavior changes
+	assert.NoError(t, c.Replay(bytes.NewReader(data)))
+
+	greeting = "hi"
+	err = c.Replay(bytes.NewReader(data))
+	var replayErr *ReplayError
+	if assert.ErrorAs(t, err, &replayErr) {
+		assert.Equal(t, 4, replayErr.Entries)
+		assert.Len(t, replayErr.Divergences, 3)
+		assert.Equal(t, `entry 2 (fail || say b): output line 2 is "hi b", expected "hello b"`, replayErr.Divergences[1].String())
+	}
+}
+
+func TestCommander_Middleware(t *testing.T) {
+	audit := []string{}
+	c, err := NewCommander(Config{
+		Middleware: []Middleware{
+			func(next Handler) Handler {
+				return func(ex *Execution) error {
+					audit = append(audit, fmt.Sprintf("%s %v", ex.Path, ex.Args["text"]))
+					return next(ex)
+				}
+			},
+			func(next Handler) Handler {
+				return func(ex *Execution) error {
+					if ex.Args.GetString("text") == "secret" {
+						return fmt.Errorf("not authorized")
+					}
+					return next(ex)
+				}
+			},
+		},
+		Commands: []*Command{
+			{
+				Name: "say",
+				SubCommands: []*Command{
+					{
+						Name:      "loud",
+						Arguments: []*Argument{{Name: "text"}},
+						Middleware: []Middleware{
+							func(next Handler) Handler {
+								return func(ex *Execution) error {
+									ex.Args["text"] = strings.ToUpper(ex.Args.GetString("text"))
+									return next(ex)
+								}
+							},
+						},
+						OnStream: func(ex *Execution) error {
+							_, err := fmt.Fprintln(ex.Stdout, ex.Args.GetString("text"))
+							return err
+						},
+					},
+				},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	stdout := &bytes.Buffer{}
+	streams := Streams{Stdout: stdout, Stderr: io.Discard}
+	err = c.executeLine(context.Background(), "say loud hello", streams)
+	assert.NoError(t, err)
+	assert.Equal(t, "HELLO\n", stdout.String())
+
+	stdout.Reset()
+	err = c.executeLine(context.Background(), "say loud secret", streams)
+	assert.EqualError(t, err, "not authorized")
+	assert.Equal(t, "", stdout.String())
+
+	assert.Equal(t, []string{"say loud hello", "say loud secret"}, audit)
+}
+
+func TestCommander_Binding(t *testing.T) {
+	type Format string
+	type GetOptions struct {
+		Output       Format        `flag:"output,o" default:"table" oneof:"json,yaml,table" desc:"output format"`
+		Limit        int           `flag:"limit" default:"10"`
+		Labels       []string      `flag:"label,l"`
+		Verbose      bool          `flag:"verbose,v"`
+		Timeout      time.Duration `flag:"timeout" default:"5s"`
+		ResourceType string        `arg:"resource-type" oneof:"process,thread"`
+		Names        []string      `arg:"name"`
+		Ignored      string
+	}
+
+	var bound *GetOptions
+	c, err := NewCommander(Config{
+		Commands: []*Command{
+			{
+				Name: "get",
+				OnBind: Bind(func(ex *Execution, options *GetOptions) error {
+					bound = options
+					return nil
+				}),
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	err = c.Execute([]string{"get", "-o", "json", "-l", "a", "--label", "b", "-v", "process", "x", "y"})
+	assert.NoError(t, err)
+	assert.Equal(t, &GetOptions{
+		Output:       "json",
+		Limit:        10,
+		Labels:       []string{"a", "b"},
+		Verbose:      true,
+		Timeout:      5 * time.Second,
+		ResourceType: "process",
+		Names:        []string{"x", "y"},
+	}, bound)
+
+	err = c.Execute([]string{"get", "-o", "xml", "process", "x"})
+	assert.Equal(t, EXIT_USAGE, ExitCode(err))
+
+	invalid := []struct {
+		options any
+		message string
+	}{
+		{struct {
+			Limit int `flag:"limit" default:"ten"`
+		}{}, "field Limit has an invalid default tag"},
+		{struct {
+			Limit map[string]int `flag:"limit"`
+		}{}, "field Limit has unsupported type map[string]int"},
+		{struct {
+			Verbose bool `flag:"verbose" oneof:"true,false"`
+		}{}, "OneOf is not compatible with boolean flags"},
+		{struct {
+			Name string `arg:"name" default:"x"`
+		}{}, "field Name is an argument, and cannot have a default value"},
+	}
+
+	for _, testCase := range invalid {
+		options := reflect.TypeOf(testCase.options)
+		cmd := &Command{
+			Name: "bad",
+			OnBind: &Binding{
+				optionsType: options,
+				handler: func(ex *Execution, options reflect.Value) error {
+					return nil
+				},
+			},
+		}
+		_, err := NewCommander(Config{Commands: []*Command{cmd}})
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), testCase.message)
+		}
+	}
+}
+
+func TestCommander_ArgMap(t *testing.T) {
+	type Format string
+	var args ArgMap
+	var sources ValueSources
+	c, err := NewCommander(Config{
+		Middleware: []Middleware{
+			// the sources of the values survive the args being rebuilt
+			func(next Handler) Handler {
+				return func(ex *Execution) error {
+					rebuilt := ArgMap{}
+					for name, value := range ex.Args {
+						rebuilt[name] = value
+					}
+					ex.Args = rebuilt
+					return next(ex)
+				}
+			},
+		},
+		Commands: []*Command{
+			{
+				Name: "get",
+				Flags: []*Flag{
+					{Name: "output", ShortName: "o", ArgType: ArgTypeString, DefaultValue: "table"},
+					{Name: "limit", ArgType: ArgTypeInt, DefaultValue: 10},
+					{Name: "since", ArgType: ArgTypeString},
+					{Name: "label", ShortName: "l", ArgType: ArgTypeString, AllowMultiple: true},
+				},
+				Arguments: []*Argument{{Name: "name", AllowMultiple: true}},
+				OnStream: func(ex *Execution) error {
+					args = ex.Args
+					sources = ex.Sources
+					return nil
+				},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	err = c.Execute([]string{"get", "--output=table", "-l", "env=prod", "--label", "tier=web", "x", "y"})
+	assert.NoError(t, err)
+
+	// the args hold nothing but the values of the flags and arguments
+	keys := []string{}
+	for key := range args {
+		keys = append(keys, key)
+	}
+	assert.ElementsMatch(t, []string{"output", "o", "limit", "since", "label", "l", "name", "help"}, keys)
+
+	output, err := Get[Format](args, "output")
+	assert.NoError(t, err)
+	assert.Equal(t, Format("table"), output)
+
+	limit, err := Get[int64](args, "limit")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), limit)
+
+	_, err = Get[int](args, "output")
+	assert.EqualError(t, err, "output is a string, not a int")
+	assert.Equal(t, 0, args.GetInt("output"))
+
+	_, ok := args.Lookup("since")
+	assert.False(t, ok)
+	since, err := Get[string](args, "since")
+	assert.NoError(t, err)
+	assert.Equal(t, "", since)
+	_, ok = args.Lookup("limit")
+	assert.True(t, ok)
+
+	assert.True(t, sources.WasSet("output"))
+	assert.True(t, sources.WasSet("o"))
+	assert.False(t, sources.WasSet("limit"))
+	assert.True(t, sources.WasSet("name"))
+
+	names, err := GetArray[string](args, "name")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"x", "y"}, names)
+
+	labels, err := GetMap[string](args, "label")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"env": "prod", "tier": "web"}, labels)
+	assert.Equal(t, labels, args.GetStringMap("l"))
+
+	_, err = GetMap[int](args, "label")
+	assert.Error(t, err)
+
+	// legacy handlers read the sources from the context, and callers of ClassifyTokens ask for them
+	var contextSources ValueSources
+	legacy := &Command{
+		Name: "legacy",
+		Flags: []*Flag{
+			{Name: "output", ArgType: ArgTypeString, DefaultValue: "table"},
+			{Name: "limit", ArgType: ArgTypeInt, DefaultValue: 10},
+		},
+		OnExecuteContext: func(ctx context.Context, c *Command, args ArgMap, capturedInput []byte) error {
+			contextSources = ContextSources(ctx)
+			return nil
+		},
+	}
+	c, err = NewCommander(Config{Commands: []*Command{legacy}})
+	assert.NoError(t, err)
+	err = c.Execute([]string{"legacy", "--limit", "10"})
+	assert.NoError(t, err)
+	assert.True(t, contextSources.WasSet("limit"))
+	assert.False(t, contextSources.WasSet("output"))
+	assert.False(t, ContextSources(context.Background()).WasSet("limit"))
+
+	_, sources, err = legacy.ClassifyTokensWithSources([]string{"--output", "table"}, nil)
+	assert.NoError(t, err)
+	assert.True(t, sources.WasSet("output"))
+	assert.False(t, sources.WasSet("limit"))
+}
+
+func TestCommander_ArgTypes(t *testing.T) {
+	var args ArgMap
+	cmd := &Command{
+		Name: "fetch",
+		Flags: []*Flag{
+			{Name: "timeout", ShortName: "t", ArgType: ArgTypeDuration, DefaultValue: 30 * time.Second},
+			{Name: "since", ArgType: ArgTypeTime},
+			{Name: "until", ArgType: ArgTypeTime},
+			{Name: "limit", ArgType: ArgTypeByteSize},
+			{Name: "from", ArgType: ArgTypeIP},
+			{Name: "network", ArgType: ArgTypeCIDR},
+			{Name: "match", ArgType: ArgTypeRegexp},
+		},
+		Arguments: []*Argument{{Name: "url", ArgType: ArgTypeURL}},
+		OnStream: func(ex *Execution) error {
+			args = ex.Args
+			return nil
+		},
+	}
+	c, err := NewCommander(Config{Commands: []*Command{cmd}})
+	assert.NoError(t, err)
+
+	err = c.Execute([]string{
+		"fetch", "--since", "-2h", "--until", "2024-05-01T10:00:00Z", "--limit", "10MiB", "--from", "10.1.2.3",
+		"--network", "10.0.0.0/8", "--match", "^a+$", "https://example.com/x",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, args.GetDuration("t"))
+	assert.WithinDuration(t, time.Now().Add(-2*time.Hour), args.GetTime("since"), time.Minute)
+	assert.Equal(t, time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), args.GetTime("until"))
+	assert.Equal(t, ByteSize(10<<20), args.GetByteSize("limit"))
+	assert.Equal(t, "10MiB", args.GetByteSize("limit").String())
+	assert.True(t, args.GetCIDR("network").Contains(args.GetIP("from")))
+	assert.True(t, args.GetRegexp("match").MatchString("aaa"))
+	assert.Equal(t, "example.com", args.GetURL("url").Host)
+
+	invalid := [][]string{
+		{"fetch", "-t", "soon", "https://example.com"},
+		{"fetch", "--since", "yesterday", "https://example.com"},
+		{"fetch", "--limit", "10XB", "https://example.com"},
+		{"fetch", "--from", "10.1.2", "https://example.com"},
+		{"fetch", "--network", "10.0.0.0", "https://example.com"},
+		{"fetch", "--match", "(", "https://example.com"},
+		{"fetch", "example.com"},
+	}
+	for _, line := range invalid {
+		err = c.Execute(line)
+		assert.Equal(t, EXIT_USAGE, ExitCode(err), line)
+	}
+
+	for text, size := range map[string]ByteSize{"512": 512, "1.5GB": 1500000000, "2k": 2000, "4KiB": 4096} {
+		parsed, err := ParseByteSize(text)
+		assert.NoError(t, err)
+		assert.Equal(t, size, parsed, text)
+	}
+
+	help := cmd.GetHelpString(nil)
+	assert.Contains(t, help, "-t / --timeout DURATION")
+	assert.Contains(t, help, "url URL")
+}
+
+type testEndpoint struct {
+	Host string
+	Port int
+}
+
+func TestCommander_CustomArgType(t *testing.T) {
+	const ArgTypeEndpoint ArgType = "ENDPOINT"
+	definition := NewArgType(ArgTypeEndpoint, func(text string) (testEndpoint, error) {
+		host, port, err := net.SplitHostPort(text)
+		if err != nil {
+			return testEndpoint{}, fmt.Errorf("value could not be parsed to host:port")
+		}
+		portNumber, err := strconv.Atoi(port)
+		return testEndpoint{Host: host, Port: portNumber}, err
+	})
+	definition.Format = func(value any) string {
+		endpoint := value.(testEndpoint)
+		return net.JoinHostPort(endpoint.Host, strconv.Itoa(endpoint.Port))
+	}
+	definition.Validate = func(value any) error {
+		if port := value.(testEndpoint).Port; port < 1 || port > 65535 {
+			return fmt.Errorf("port %d is out of range", port)
+		}
+		return nil
+	}
+	definition.Completer = func(search string) *ns.Suggestions {
+		suggestions := ns.NewSuggestions()
+		suggestions.Add(ns.NewSuggestion("localhost:8080", "localhost:8080"))
+		return suggestions
+	}
+	// the type is registered with a registry of this test's own, such that the test may be repeated
+	registry := argTypes
+	argTypes = newArgTypeRegistry(builtinArgTypes()...)
+	t.Cleanup(func() { argTypes = registry })
+
+	assert.NoError(t, RegisterArgType(definition))
+	assert.Error(t, RegisterArgType(definition))
+	assert.Equal(t, ArgTypeEndpoint, InferArgType(testEndpoint{}))
+
+	var args ArgMap
+	cmd := &Command{
+		Name: "connect",
+		Flags: []*Flag{
+			{Name: "proxy", ArgType: ArgTypeEndpoint, OneOf: []any{testEndpoint{"proxy", 3128}, testEndpoint{"proxy", 8080}}},
+		},
+		Arguments: []*Argument{{Name: "endpoint", ArgType: ArgTypeEndpoint, AllowMultiple: true}},
+		OnStream: func(ex *Execution) error {
+			args = ex.Args
+			return nil
+		},
+	}
+	c, err := NewCommander(Config{Commands: []*Command{cmd}})
+	assert.NoError(t, err)
+
+	err = c.Execute([]string{"connect", "--proxy", "proxy:8080", "a:1", "b:2"})
+	assert.NoError(t, err)
+	proxy, err := Get[testEndpoint](args, "proxy")
+	assert.NoError(t, err)
+	assert.Equal(t, testEndpoint{"proxy", 8080}, proxy)
+	endpoints, err := GetArray[testEndpoint](args, "endpoint")
+	assert.NoError(t, err)
+	assert.Equal(t, []testEndpoint{{"a", 1}, {"b", 2}}, endpoints)
+
+	for _, line := range [][]string{
+		{"connect", "--proxy", "proxy:9090", "a:1"},
+		{"connect", "a:70000"},
+		{"connect", "a"},
+	} {
+		err = c.Execute(line)
+		assert.Equal(t, EXIT_USAGE, ExitCode(err), line)
+	}
+
+	assert.Contains(t, cmd.GetHelpString(nil), `one of "proxy:3128", "proxy:8080"`)
+	assert.Len(t, cmd.Arguments[0].SuggestValues("").Items, 1)
+
+	_, err = NewCommander(Config{Commands: []*Command{{
+		Name:      "bad",
+		Arguments: []*Argument{{Name: "endpoint", ArgType: ArgTypeEndpoint, OneOf: []any{"a:1"}}},
+		OnStream:  func(ex *Execution) error { return nil },
+	}}})
+	assert.Error(t, err)
+}
+
+func TestCommander_Constraints(t *testing.T) {
+	cmd := &Command{
+		Name: "serve",
+		Flags: []*Flag{
+			{Name: "port", ShortName: "p", ArgType: ArgTypeInt, Min: 1, Max: 65535, DefaultValue: 8080},
+			{Name: "timeout", ArgType: ArgTypeDuration, Min: time.Second},
+			{Name: "ratio", ArgType: ArgTypeFloat, Max: 1},
+			{
+				Name:    "user",
+				ArgType: ArgTypeString,
+				Pattern: regexp.MustCompile(`^[a-z]+$`),
+				MinLen:  3,
+				MaxLen:  8,
+				Completer: func(search string) *ns.Suggestions {
+					suggestions := ns.NewSuggestions()
+					for _, user := range []string{"al", "alice", "Bob", "carol"} {
+						suggestions.Add(ns.NewSuggestion(user, user))
+					}
+					return suggestions
+				},
+			},
+			{Name: "mode", ArgType: ArgTypeString, Validator: func(value any) error {
+				if value.(string) == "unsafe" {
+					return fmt.Errorf("unsafe mode is not permitted")
+				}
+				return nil
+			}},
+		},
+		OnStream: func(ex *Execution) error { return nil },
+	}
+	c, err := NewCommander(Config{Commands: []*Command{cmd}})
+	assert.NoError(t, err)
+
+	assert.NoError(t, c.Execute([]string{"serve", "-p", "443", "--timeout", "5s", "--ratio", "0.5", "--user", "alice"}))
+
+	invalid := []struct {
+		args    []string
+		message string
+	}{
+		{[]string{"-p", "0"}, "invalid value for flag -p / --port: 0 is less than the minimum of 1"},
+		{[]string{"--port=70000"}, "invalid value for flag -p / --port: 70000 is greater than the maximum of 65535"},
+		{[]string{"--timeout", "10ms"}, "invalid value for flag --timeout: 10ms is less than the minimum of 1s"},
+		{[]string{"--ratio", "1.5"}, "invalid value for flag --ratio: 1.5 is greater than the maximum of 1"},
+		{[]string{"--user", "al"}, "invalid value for flag --user: \"al\" is shorter than the minimum length of 3"},
+		{[]string{"--user", "Bob1"}, "invalid value for flag --user: \"Bob1\" does not match the pattern ^[a-z]+$"},
+		{[]string{"--mode", "unsafe"}, "invalid value for flag --mode: unsafe mode is not permitted"},
+	}
+	for _, testCase := range invalid {
+		_, err := cmd.ClassifyTokens(testCase.args, nil)
+		assert.EqualError(t, err, testCase.message)
+	}
+
+	suggestions := cmd.Flags[3].SuggestValues("")
+	values := []string{}
+	for _, suggestion := range suggestions.Items {
+		values = append(values, suggestion.Value)
+	}
+	assert.Equal(t, []string{"alice", "carol"}, values)
+
+	help := cmd.GetHelpString(nil)
+	assert.Contains(t, help, "between 1 and 65535")
+	assert.Contains(t, help, "at least 1s")
+	assert.Contains(t, help, "3 to 8 characters - matching ^[a-z]+$")
+
+	_, err = NewCommander(Config{Commands: []*Command{{
+		Name:     "bad",
+		Flags:    []*Flag{{Name: "port", ArgType: ArgTypeInt, Min: "1"}},
+		OnStream: func(ex *Execution) error { return nil },
+	}}})
+	assert.Error(t, err)
+}
+
+func TestCommander_FlagGroups(t *testing.T) {
+	cmd := &Command{
+		Name: "connect",
+		Flags: []*Flag{
+			{Name: "all", ShortName: "a"},
+			{Name: "name", ArgType: ArgTypeString},
+			{Name: "user", ArgType: ArgTypeString},
+			{Name: "password", ArgType: ArgTypeString},
+			{Name: "output", ArgType: ArgTypeString, DefaultValue: "table"},
+			{Name: "path", ArgType: ArgTypeString},
+		},
+		MutuallyExclusive: [][]string{{"all", "name"}},
+		RequiredTogether:  [][]string{{"user", "password"}},
+		OneRequired:       [][]string{{"all", "name"}},
+		RequiredIf:        map[string][]string{"output=file": {"path"}},
+		OnStream:          func(ex *Execution) error { return nil },
+	}
+	c, err := NewCommander(Config{Commands: []*Command{cmd}})
+	assert.NoError(t, err)
+
+	valid := [][]string{
+		{"-a"},
+		{"--name", "x", "--user", "u", "--password", "p"},
+		{"--all", "--output", "file", "--path", "out.txt"},
+	}
+	for _, args := range valid {
+		_, err := cmd.ClassifyTokens(args, nil)
+		assert.NoError(t, err, args)
+	}
+
+	invalid := []struct {
+		args    []string
+		message string
+	}{
+		{[]string{"-a", "--name", "x"}, "command \"connect\" - flags --all and --name cannot be used together"},
+		{[]string{"-a", "--user", "u"}, "command \"connect\" - flags --user and --password must be used together"},
+		{[]string{"--user", "u", "--password", "p"}, "command \"connect\" - one of the flags --all or --name is required"},
+		{[]string{"-a", "--output=file"}, "command \"connect\" - flag --path is required when --output is file"},
+	}
+	for _, testCase := range invalid {
+		_, err := cmd.ClassifyTokens(testCase.args, nil)
+		assert.EqualError(t, err, testCase.message)
+	}
+
+	err = c.Execute([]string{"connect", "-a", "--name", "x"})
+	assert.Equal(t, EXIT_USAGE, ExitCode(err))
+
+	help := cmd.GetHelpString(nil)
+	assert.Contains(t, help, "Flag constraints:\n  --all and --name cannot be used together")
+	assert.Contains(t, help, "--path is required when --output is file")
+
+	suggestions := cmd.Suggest([]string{"-a", "--"}, nil)
+	values := []string{}
+	for _, suggestion := range suggestions.Items {
+		values = append(values, suggestion.Value)
+	}
+	assert.NotContains(t, values, "--name")
+	assert.Contains(t, values, "--user")
+
+	_, err = NewCommander(Config{Commands: []*Command{{
+		Name:              "bad",
+		Flags:             []*Flag{{Name: "all"}},
+		MutuallyExclusive: [][]string{{"all", "none"}},
+		OnStream:          func(ex *Execution) error { return nil },
+	}}})
+	assert.Error(t, err)
+}
+
+func TestCommander_FlagDefaults(t *testing.T) {
+	defaultsFile := filepath.Join(t.TempDir(), "defaults.yaml")
+	err := os.WriteFile(defaultsFile, []byte(`
+"*":
+  output: yaml
+get process:
+  namespace: prod
+  l: [tier=web, env=prod]
+`), 0644)
+	assert.NoError(t, err)
+
+	var args ArgMap
+	var sources ValueSources
+	handler := func(ex *Execution) error {
+		args = ex.Args
+		sources = ex.Sources
+		return nil
+	}
+	c, err := NewCommander(Config{
+		DefaultsFile: defaultsFile,
+		Commands: []*Command{
+			{
+				Name: "get",
+				Flags: []*Flag{
+					{Name: "output", ShortName: "o", ArgType: ArgTypeString, DefaultValue: "table", OneOf: []any{"table", "json", "yaml"}},
+				},
+				SubCommands: []*Command{
+					{
+						Name: "process",
+						Flags: []*Flag{
+							{Name: "namespace", ArgType: ArgTypeString, EnvVar: "COMMANDER_TEST_NAMESPACE", IsRequired: true},
+							{Name: "label", ShortName: "l", ArgType: ArgTypeString, AllowMultiple: true},
+							{Name: "limit", ArgType: ArgTypeInt, DefaultValue: 10},
+						},
+						OnStream: handler,
+					},
+				},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, c.Execute([]string{"get", "process"}))
+	assert.Equal(t, "yaml", args.GetString("output"))
+	assert.Equal(t, ValueSourceDefaultsFile, sources.Source("o"))
+	assert.Equal(t, "prod", args.GetString("namespace"))
+	assert.Equal(t, []string{"tier=web", "env=prod"}, args.GetStringArray("label"))
+	assert.Equal(t, ValueSourceDefault, sources.Source("limit"))
+	assert.False(t, sources.WasSet("namespace"))
+
+	t.Setenv("COMMANDER_TEST_NAMESPACE", "staging")
+	assert.NoError(t, c.Execute([]string{"get", "process", "-o", "json"}))
+	assert.Equal(t, "json", args.GetString("output"))
+	assert.Equal(t, ValueSourceCommandLine, sources.Source("output"))
+	assert.Equal(t, "staging", args.GetString("namespace"))
+	assert.Equal(t, ValueSourceEnv, sources.Source("namespace"))
+
+	assert.NoError(t, c.Execute([]string{"get", "process", "--namespace", "dev"}))
+	assert.Equal(t, "dev", args.GetString("namespace"))
+	assert.True(t, sources.WasSet("namespace"))
+
+	t.Setenv("COMMANDER_TEST_NAMESPACE", "")
+	err = os.WriteFile(defaultsFile, []byte("get:\n  output: xml\n"), 0644)
+	assert.NoError(t, err)
+	c.defaults, err = loadDefaultsFile(defaultsFile)
+	assert.NoError(t, err)
+	err = c.Execute([]string{"get", "process"})
+	assert.Equal(t, EXIT_USAGE, ExitCode(err))
+	assert.ErrorContains(t, err, "invalid value for flag -o / --output from the defaults file")
+
+	err = os.WriteFile(defaultsFile, []byte("get process:\n  verbose: true\n"), 0644)
+	assert.NoError(t, err)
+	_, err = NewCommander(Config{
+		DefaultsFile: defaultsFile,
+		Commands:     []*Command{{Name: "get", SubCommands: []*Command{{Name: "process", OnStream: handler}}}},
+	})
+	assert.EqualError(t, err, "unable to load flag defaults: command \"get process\" has no flag \"verbose\"")
+}
+
+func TestCommander_FlagGroupSources(t *testing.T) {
+	defaultsFile := filepath.Join(t.TempDir(), "defaults.yaml")
+	err := os.WriteFile(defaultsFile, []byte("connect:\n  path: out.txt\n"), 0644)
+	assert.NoError(t, err)
+
+	cmd := &Command{
+		Name: "connect",
+		Flags: []*Flag{
+			{Name: "all", ShortName: "a", EnvVar: "COMMANDER_TEST_ALL"},
+			{Name: "name", ArgType: ArgTypeString, EnvVar: "COMMANDER_TEST_NAME"},
+			{Name: "user", ArgType: ArgTypeString, EnvVar: "COMMANDER_TEST_USER"},
+			{Name: "password", ArgType: ArgTypeString},
+			{Name: "output", ArgType: ArgTypeString, DefaultValue: "table"},
+			{Name: "path", ArgType: ArgTypeString},
+		},
+		MutuallyExclusive: [][]string{{"all", "name"}},
+		RequiredTogether:  [][]string{{"user", "password"}},
+		OneRequired:       [][]string{{"all", "name"}},
+		RequiredIf:        map[string][]string{"output=file": {"path"}},
+		OnStream:          func(ex *Execution) error { return nil },
+	}
+	_, err = NewCommander(Config{DefaultsFile: defaultsFile, Commands: []*Command{cmd}})
+	assert.NoError(t, err)
+
+	// values from the environment and the defaults file count towards the groups
+	t.Setenv("COMMANDER_TEST_NAME", "x")
+	args, sources, err := cmd.classifyTokens([]string{"--output", "file"}, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "x", ArgMap(args).GetString("name"))
+		assert.Equal(t, ValueSourceEnv, sources.Source("name"))
+		assert.Equal(t, ValueSourceDefaultsFile, sources.Source("path"))
+	}
+
+	// a flag given on the command line discards the fallback values of those it excludes
+	args, sources, err = cmd.classifyTokens([]string{"-a"}, nil)
+	if assert.NoError(t, err) {
+		assert.True(t, ArgMap(args).GetBool("all"))
+		_, ok := ArgMap(args).Lookup("name")
+		assert.False(t, ok)
+		assert.Equal(t, ValueSourceNone, sources.Source("name"))
+	}
+
+	invalid := []struct {
+		all     string
+		name    string
+		user    string
+		args    []string
+		message string
+	}{
+		{"true", "x", "", []string{}, "command \"connect\" - flags --all and --name cannot be used together, but are given by the environment"},
+		{"", "x", "u", []string{}, "command \"connect\" - flags --user and --password must be used together"},
+		{"", "", "", []string{"-a", "--password", "p"}, "command \"connect\" - flags --user and --password must be used together"},
+		{"", "", "", []string{}, "command \"connect\" - one of the flags --all or --name is required"},
+	}
+	for _, testCase := range invalid {
+		t.Setenv("COMMANDER_TEST_ALL", testCase.all)
+		t.Setenv("COMMANDER_TEST_NAME", testCase.name)
+		t.Setenv("COMMANDER_TEST_USER", testCase.user)
+		_, _, err := cmd.classifyTokens(testCase.args, nil)
+		assert.EqualError(t, err, testCase.message, testCase.args)
+	}
+
+	// the environment may supply one flag of a group which must be used together, and the command line the other
+	t.Setenv("COMMANDER_TEST_USER", "u")
+	_, _, err = cmd.classifyTokens([]string{"-a", "--password", "p"}, nil)
+	assert.NoError(t, err)
+}
+
+func TestCommander_ShortFlagClusters(t *testing.T) {
+	cmd := &Command{
+		Name: "get",
+		Flags: []*Flag{
+			{Name: "verbose", ShortName: "v"},
+			{Name: "force", ShortName: "f"},
+			{Name: "output", ShortName: "o", ArgType: ArgTypeString, OneOf: []any{"json", "yaml", "table"}},
+		},
+		OnStream: func(ex *Execution) error { return nil },
+	}
+	_, err := NewCommander(Config{Commands: []*Command{cmd}})
+	assert.NoError(t, err)
+
+	valid := []struct {
+		args     []string
+		expected map[string]any
+	}{
+		{[]string{"-vf"}, map[string]any{"verbose": true, "force": true, "output": nil}},
+		{[]string{"-ojson"}, map[string]any{"verbose": false, "force": false, "output": "json"}},
+		{[]string{"-o=yaml"}, map[string]any{"verbose": false, "force": false, "output": "yaml"}},
+		{[]string{"-vfo", "table"}, map[string]any{"verbose": true, "force": true, "output": "table"}},
+		{[]string{"-vojson"}, map[string]any{"verbose": true, "force": false, "output": "json"}},
+		{[]string{"-v=false"}, map[string]any{"verbose": false, "force": false, "output": nil}},
+	}
+	for _, testCase := range valid {
+		args, err := cmd.ClassifyTokens(testCase.args, nil)
+		if assert.NoError(t, err, testCase.args) {
+			for name, value := range testCase.expected {
+				assert.Equal(t, value, args[name], testCase.args)
+			}
+		}
+	}
+
+	invalid := []struct {
+		args    []string
+		message string
+	}{
+		{[]string{"-vx"}, "unrecognized flag -x in -vx"},
+		{[]string{"-output", "json"}, "invalid value for flag -o / --output: \"utput\" does not belong to the collection defined by the flag, did you mean --output"},
+		{[]string{"-force"}, "invalid value for flag -o / --output: \"rce\" does not belong to the collection defined by the flag, did you mean --force"},
+		{[]string{"-o=jsn"}, "invalid value for flag -o / --output: \"jsn\" does not belong to the collection defined by the flag"},
+		{[]string{"-ojsn"}, "invalid value for flag -o / --output: \"jsn\" does not belong to the collection defined by the flag"},
+	}
+	for _, testCase := range invalid {
+		_, err := cmd.ClassifyTokens(testCase.args, nil)
+		assert.EqualError(t, err, testCase.message)
+	}
+
+	values := func(suggestions *ns.Suggestions) []string {
+		result := []string{}
+		for _, suggestion := range suggestions.Items {
+			result = append(result, suggestion.Value)
+		}
+		return result
+	}
+	assert.Equal(t, []string{"-v", "-vf", "-vo"}, values(cmd.Suggest([]string{"-v"}, nil)))
+	assert.Equal(t, []string{"-vojson"}, values(cmd.Suggest([]string{"-voj"}, nil)))
+	assert.Equal(t, []string{"-o=yaml"}, values(cmd.Suggest([]string{"-o=y"}, nil)))
+	assert.Equal(t, []string{"json", "yaml", "table"}, values(cmd.Suggest([]string{"-vo", ""}, nil)))
+
+	assert.Contains(t, cmd.GetHelpString(nil), "Short flags may be combined, ex. -vf, and given attached values, ex. -oVALUE or -o=VALUE")
+}
+
+func TestCommander_NegatableAndCountFlags(t *testing.T) {
+	cmd := &Command{
+		Name: "log",
+		Flags: []*Flag{
+			{Name: "timestamp", ShortName: "t", DefaultValue: true, Description: "show timestamps"},
+			{Name: "verbose", ShortName: "v", ArgType: ArgTypeCount, Max: 3},
+			{Name: "quiet", ShortName: "q"},
+		},
+		OnStream: func(ex *Execution) error { return nil },
+	}
+	_, err := NewCommander(Config{Commands: []*Command{cmd}})
+	assert.NoError(t, err)
+
+	valid := []struct {
+		args      []string
+		timestamp bool
+		verbose   int
+	}{
+		{[]string{}, true, 0},
+		{[]string{"--timestamp"}, true, 0},
+		{[]string{"-t"}, true, 0},
+		{[]string{"--no-timestamp"}, false, 0},
+		{[]string{"--timestamp=false"}, false, 0},
+		{[]string{"-vvv"}, true, 3},
+		{[]string{"--verbose", "-v", "--no-timestamp"}, false, 2},
+		{[]string{"-qvt"}, true, 1},
+		{[]string{"--verbose=2"}, true, 2},
+	}
+	for _, testCase := range valid {
+		args, err := cmd.ClassifyTokens(testCase.args, nil)
+		if assert.NoError(t, err, testCase.args) {
+			assert.Equal(t, testCase.timestamp, ArgMap(args).GetBool("timestamp"), testCase.args)
+			assert.Equal(t, testCase.verbose, ArgMap(args).GetInt("v"), testCase.args)
+		}
+	}
+
+	_, err = cmd.ClassifyTokens([]string{"--no-timestamp=true"}, nil)
+	assert.EqualError(t, err, "flag --no-timestamp does not take a value")
+	_, err = cmd.ClassifyTokens([]string{"--no-verbose"}, nil)
+	assert.EqualError(t, err, "unrecognized flag no-verbose")
+	_, err = cmd.ClassifyTokens([]string{"-vvvv"}, nil)
+	assert.EqualError(t, err, "invalid value for flag -v / --verbose: 4 is greater than the maximum of 3")
+
+	values := func(suggestions *ns.Suggestions) []string {
+		result := []string{}
+		for _, suggestion := range suggestions.Items {
+			result = append(result, suggestion.Value)
+		}
+		return result
+	}
+	assert.Equal(t, []string{"--timestamp", "--no-timestamp", "--verbose", "--quiet", "--no-quiet", "--help"}, values(cmd.Suggest([]string{"--"}, nil)))
+	assert.Equal(t, []string{"--no-timestamp", "--no-quiet"}, values(cmd.Suggest([]string{"--no"}, nil)))
+	assert.Contains(t, values(cmd.Suggest([]string{"-vv"}, nil)), "-vvv")
+
+	help := cmd.GetHelpString(nil)
+	assert.Contains(t, help, "-t / --[no-]timestamp")
+	assert.Contains(t, help, "may be repeated, ex. -vvv")
+}
+
+func TestCommander_Aliases(t *testing.T) {
+	aliasFile := filepath.Join(t.TempDir(), "aliases")
+	stdout := &bytes.Buffer{}
+	newCommander := func() *Commander {
+		c, err := NewCommander(Config{
+			AliasFile: aliasFile,
+			Aliases: map[string]string{
+				"hi": "say hello",
+			},
+			Commands: []*Command{
+				{
+					Name:      "say",
+					Arguments: []*Argument{{Name: "text", AllowMultiple: true}},
+					OnStream: func(ex *Execution) error {
+						_, err := fmt.Fprintln(ex.Stdout, strings.Join(ex.Args.GetStringArray("text"), " "))
+						return err
+					},
+				},
+			},
+		})
+		assert.NoError(t, err)
+		return c
+	}
+
+	c := newCommander()
+	streams := Streams{Stdout: stdout, Stderr: io.Discard}
+	err := c.executeLine(context.Background(), "alias 'greet=hi there' 'find=grep hello' 'say=say -- HEY'", streams)
+	assert.NoError(t, err)
+
+	err = c.executeLine(context.Background(), "greet world | find", streams)
+	assert.NoError(t, err)
+	assert.Equal(t, "HEY hello there world\n", stdout.String())
+
+	// aliases are restored from the alias file
+	c = newCommander()
+	value, ok := c.GetAlias("find")
+	assert.True(t, ok)
+	assert.Equal(t, "grep hello", value)
+
+	stdout.Reset()
+	err = c.executeLine(context.Background(), "say a", streams)
+	assert.NoError(t, err)
+	assert.Equal(t, "HEY a\n", stdout.String())
+
+	stdout.Reset()
+	assert.NoError(t, c.RemoveAlias("say"))
+	err = c.executeLine(context.Background(), "say a", streams)
+	assert.NoError(t, err)
+	assert.Equal(t, "a\n", stdout.String())
+}
+
+func TestCommander_Jobs(t *testing.T) {
+	release := make(chan struct{})
+	c, err := NewCommander(Config{
+		Commands: []*Command{
+			{
+				Name:      "say",
+				Arguments: []*Argument{{Name: "text", AllowMultiple: true}},
+				OnStream: func(ex *Execution) error {
+					_, err := fmt.Fprintln(ex.Stdout, strings.Join(ex.Args.GetStringArray("text"), " "))
+					return err
+				},
+			},
+			{
+				Name: "block",
+				OnStream: func(ex *Execution) error {
+					select {
+					case <-release:
+						return nil
+					case <-ex.Context().Done():
+						return ex.Context().Err()
+					}
+				},
+			},
+			{
+				Name: "legacy",
+				OnExecute: func(c *Command, args ArgMap, capturedInput []byte) error {
+					return nil
+				},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	streams := Streams{Stdout: stdout, Stderr: stderr}
+
+	// output is held until the job is reported
+	err = c.executeLine(context.Background(), "block && say done &", streams)
+	assert.NoError(t, err)
+	assert.Equal(t, "[1] block && say done"+C_RESET+"\n", stderr.String())
+	job, ok := c.GetJob(1)
+	assert.True(t, ok)
+	assert.Equal(t, JobStateRunning, job.State())
+
+	release <- struct{}{}
+	assert.NoError(t, job.Wait())
+	assert.Equal(t, "", stdout.String())
+
+	stderr.Reset()
+	c.reportJobs(streams)
+	assert.Equal(t, "done\n", stdout.String())
+	assert.Equal(t, "[1] done  block && say done"+C_RESET+"\n", stderr.String())
+	assert.Empty(t, c.Jobs())
+
+	// a killed job reports the cause
+	stderr.Reset()
+	err = c.executeLine(context.Background(), "say one & block & say two", streams)
+	assert.NoError(t, err)
+	job, ok = c.GetJob(2)
+	assert.True(t, ok)
+	err = c.executeLine(context.Background(), "kill 2", streams)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, job.Wait(), ErrJobKilled)
+
+	// bringing a job to the foreground attaches its output
+	stdout.Reset()
+	err = c.executeLine(context.Background(), "fg 1", streams)
+	assert.NoError(t, err)
+	assert.Equal(t, "say one"+C_RESET+"\none\n", stdout.String())
+	assert.Len(t, c.Jobs(), 1)
+
+	err = c.executeLine(context.Background(), "fg 1", streams)
+	assert.EqualError(t, err, "no such job 1")
+
+	c.reportJobs(streams)
+
+	// commands with legacy handlers are refused before a job is started
+	err = c.executeLine(context.Background(), "legacy & wait", streams)
+	assert.NoError(t, err)
+	err = c.executeLine(context.Background(), "say a && legacy &", streams)
+	assert.EqualError(t, err, "command \"legacy\" writes to the process' stdout, and cannot run in the background")
+	assert.Equal(t, EXIT_USAGE, c.LastStatus())
+	assert.Empty(t, c.Jobs())
+
+	// scripts may be sourced concurrently, and each is limited only by its own nesting
+	script := filepath.Join(t.TempDir(), "say.cmd")
+	assert.NoError(t, os.WriteFile(script, []byte("say sourced\n"), 0644))
+	err = c.executeLine(context.Background(), fmt.Sprintf("source %[1]s & source %[1]s & source %[1]s", script), streams)
+	assert.NoError(t, err)
+	for _, job := range c.Jobs() {
+		assert.NoError(t, job.Wait())
+	}
+
+	recursive := filepath.Join(t.TempDir(), "recursive.cmd")
+	assert.NoError(t, os.WriteFile(recursive, []byte(fmt.Sprintf("source %s\n", recursive)), 0644))
+	err = c.executeLine(context.Background(), fmt.Sprintf("source %s", recursive), streams)
+	assert.ErrorContains(t, err, "maximum script depth of 32 exceeded")
+}
+
+func TestCommander_JobReports(t *testing.T) {
+	release := make(chan struct{})
+	output := &syncWriter{target: &bytes.Buffer{}}
+	c, err := NewCommander(Config{
+		Stdout: output,
+		Stderr: output,
+		Commands: []*Command{
+			{
+				Name:      "say",
+				Arguments: []*Argument{{Name: "text", AllowMultiple: true}},
+				OnStream: func(ex *Execution) error {
+					_, err := fmt.Fprintln(ex.Stdout, strings.Join(ex.Args.GetStringArray("text"), " "))
+					return err
+				},
+			},
+			{
+				Name: "block",
+				OnStream: func(ex *Execution) error {
+					<-release
+					return nil
+				},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	// a job completing while the shell awaits input is reported before the next line's output
+	assert.NoError(t, c.shellExecutionFunc("block && say background &"))
+	job, ok := c.GetJob(1)
+	assert.True(t, ok)
+	close(release)
+	assert.NoError(t, job.Wait())
+
+	output.target.(*bytes.Buffer).Reset()
+	assert.NoError(t, c.shellExecutionFunc("say foreground"))
+	assert.Equal(t, "background\n[1] done  block && say background"+C_RESET+"\nforeground\n", output.target.(*bytes.Buffer).String())
+	assert.Empty(t, c.Jobs())
+}
+
+func TestJobOutput_Spill(t *testing.T) {
+	output := &jobOutput{}
+	expected := &bytes.Buffer{}
+	chunk := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	for expected.Len() <= MAX_JOB_BUFFER*3/2 {
+		_, err := output.Write(chunk)
+		assert.NoError(t, err)
+		expected.Write(chunk)
+	}
+
+	// output beyond the limit is held in a file, rather than in memory
+	assert.NotNil(t, output.spill)
+	assert.LessOrEqual(t, output.buffer.Len(), MAX_JOB_BUFFER)
+	spill := output.spill.Name()
+
+	actual := &bytes.Buffer{}
+	output.attach(actual)
+	assert.Equal(t, expected.Bytes(), actual.Bytes())
+	assert.NoFileExists(t, spill)
+
+	_, err := output.Write([]byte("attached"))
+	assert.NoError(t, err)
+	assert.Equal(t, "attached", strings.TrimPrefix(actual.String(), expected.String()))
+}
+
 func TestCommander(t *testing.T) {
 	suite.Run(t, new(CommanderTestSuite))
 }
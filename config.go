@@ -1,7 +1,26 @@
 package commander
 
+import "io"
+
 type Config struct {
 	PromptFunc func() string
 	Commands   []*Command
-	DumpFile   string // For debugging purposes, all input will be sent to this file, if set
+	DumpFile   string            // If set, a transcript of every line executed is appended to this file as JSON lines (see Replay)
+	DumpOutput bool              // If enabled, the output of each line is included in the transcript
+	Stdin      io.Reader         // Input to the first command when executing non-interactively, commands receive no input if unset
+	Stdout     io.Writer         // Defaults to os.Stdout
+	Stderr     io.Writer         // Defaults to os.Stderr
+	ExpandEnv  bool              // If enabled, variables not defined in the session are looked up in the environment
+	Aliases    map[string]string // Aliases available in every session, mapping an alias name to the text it expands to
+	AliasFile  string            // If set, aliases are loaded from this file and saved to it whenever they change
+
+	HistoryFile      string // If set, shell history is loaded from this file and saved to it as lines are entered
+	HistorySize      int    // Maximum number of history entries retained, defaults to 1000
+	HistoryNamespace string // Separates the history of this application from that of others sharing the HistoryFile
+
+	Middleware []Middleware // Wraps the execution of every command, the first listed being the outermost
+
+	// DefaultsFile is a YAML file of flag defaults by command path, which apply when a flag is given neither on the
+	// command line nor by its EnvVar, ex. {"get process": {"output": "yaml"}}.  the "*" path applies to every command.
+	DefaultsFile string
 }
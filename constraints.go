@@ -0,0 +1,165 @@
+package commander
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"time"
+	"unicode/utf8"
+
+	ns "github.com/hashibuto/nilshell"
+)
+
+// valueConstraints are the declarative constraints of a flag or argument, which each value given on the command line
+// must satisfy
+type valueConstraints struct {
+	argType  ArgType
+	min      any
+	max      any
+	pattern  *regexp.Regexp
+	minLen   int
+	maxLen   int
+	validate func(value any) error
+}
+
+// validateConstraints returns an error if the constraints don't suit the type of the flag or argument
+func (c *valueConstraints) validateConstraints() error {
+	boundType := c.argType
+	if boundType == ArgTypeCount {
+		boundType = ArgTypeInt
+	}
+
+	for _, bound := range []any{c.min, c.max} {
+		if bound == nil {
+			continue
+		}
+		if !isOrdered(bound) {
+			return fmt.Errorf("Min and Max must be numbers or times, not %T", bound)
+		}
+		if InferArgType(bound) != boundType && !(boundType == ArgTypeFloat && InferArgType(bound) == ArgTypeInt) {
+			return fmt.Errorf("Min and Max must be of the argument type \"%s\"", boundType)
+		}
+	}
+
+	if c.min != nil && c.max != nil && compareValues(c.min, c.max) > 0 {
+		return fmt.Errorf("Min cannot be greater than Max")
+	}
+
+	if c.minLen < 0 || c.maxLen < 0 || (c.maxLen > 0 && c.minLen > c.maxLen) {
+		return fmt.Errorf("MinLen and MaxLen must be positive, and MinLen cannot be greater than MaxLen")
+	}
+
+	return nil
+}
+
+// check returns an error describing the first constraint which the value, given on the command line as text, fails
+func (c *valueConstraints) check(text string, value any) error {
+	length := utf8.RuneCountInString(text)
+	if c.minLen > 0 && length < c.minLen {
+		return fmt.Errorf("\"%s\" is shorter than the minimum length of %d", text, c.minLen)
+	}
+
+	if c.maxLen > 0 && length > c.maxLen {
+		return fmt.Errorf("\"%s\" is longer than the maximum length of %d", text, c.maxLen)
+	}
+
+	if c.pattern != nil && !c.pattern.MatchString(text) {
+		return fmt.Errorf("\"%s\" does not match the pattern %s", text, c.pattern)
+	}
+
+	if c.min != nil && compareValues(value, c.min) < 0 {
+		return fmt.Errorf("%s is less than the minimum of %s", FormatValue(value), FormatValue(c.min))
+	}
+
+	if c.max != nil && compareValues(value, c.max) > 0 {
+		return fmt.Errorf("%s is greater than the maximum of %s", FormatValue(value), FormatValue(c.max))
+	}
+
+	if c.validate != nil {
+		return c.validate(value)
+	}
+
+	return nil
+}
+
+// describe returns a description of each constraint, for help
+func (c *valueConstraints) describe() []string {
+	description := []string{}
+	switch {
+	case c.min != nil && c.max != nil:
+		description = append(description, fmt.Sprintf("between %s and %s", FormatValue(c.min), FormatValue(c.max)))
+	case c.min != nil:
+		description = append(description, fmt.Sprintf("at least %s", FormatValue(c.min)))
+	case c.max != nil:
+		description = append(description, fmt.Sprintf("at most %s", FormatValue(c.max)))
+	}
+
+	switch {
+	case c.minLen > 0 && c.maxLen > 0:
+		description = append(description, fmt.Sprintf("%d to %d characters", c.minLen, c.maxLen))
+	case c.minLen > 0:
+		description = append(description, fmt.Sprintf("at least %d characters", c.minLen))
+	case c.maxLen > 0:
+		description = append(description, fmt.Sprintf("at most %d characters", c.maxLen))
+	}
+
+	if c.pattern != nil {
+		description = append(description, fmt.Sprintf("matching %s", c.pattern))
+	}
+
+	return description
+}
+
+// isEmpty returns true if there are no constraints
+func (c *valueConstraints) isEmpty() bool {
+	return c.min == nil && c.max == nil && c.pattern == nil && c.minLen == 0 && c.maxLen == 0 && c.validate == nil
+}
+
+// filter removes the suggestions whose values would be rejected
+func (c *valueConstraints) filter(suggestions *ns.Suggestions) *ns.Suggestions {
+	if suggestions == nil {
+		return nil
+	}
+
+	filtered := ns.NewSuggestions()
+	for _, suggestion := range suggestions.Items {
+		value, err := GetValueFromString(c.argType, suggestion.Value)
+		if err == nil && c.check(suggestion.Value, value) == nil {
+			filtered.Add(suggestion)
+		}
+	}
+
+	return filtered
+}
+
+// isOrdered returns true if values of the same type as the value can be compared with compareValues
+func isOrdered(value any) bool {
+	if _, ok := value.(time.Time); ok {
+		return true
+	}
+
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Float32, reflect.Float64:
+		return true
+	}
+
+	return false
+}
+
+// compareValues returns -1, 0 or 1 as a is less than, equal to, or greater than b, which must be ordered
+func compareValues(a any, b any) int {
+	if timeA, ok := a.(time.Time); ok {
+		return timeA.Compare(b.(time.Time))
+	}
+
+	floatA := reflect.ValueOf(a).Convert(floatType).Float()
+	floatB := reflect.ValueOf(b).Convert(floatType).Float()
+	switch {
+	case floatA < floatB:
+		return -1
+	case floatA > floatB:
+		return 1
+	}
+
+	return 0
+}
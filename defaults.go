@@ -0,0 +1,156 @@
+package commander
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ValueSource describes where the value of a flag or argument came from
+type ValueSource string
+
+const (
+	ValueSourceNone         ValueSource = ""              // the flag has no value
+	ValueSourceCommandLine  ValueSource = "command line"  // given explicitly
+	ValueSourceEnv          ValueSource = "environment"   // read from the flag's EnvVar
+	ValueSourceDefaultsFile ValueSource = "defaults file" // read from the Config.DefaultsFile
+	ValueSourceDefault      ValueSource = "default"       // the flag's DefaultValue
+)
+
+const (
+	// ALL_COMMANDS is the command path of the section of a defaults file which applies to every command
+	ALL_COMMANDS = "*"
+)
+
+// flagDefaults maps a command path (ex. "get process") to the flag defaults of that command, by flag name.  each flag
+// default is held as text, to be parsed in the same way as a value given on the command line.
+type flagDefaults map[string]map[string][]string
+
+// loadDefaultsFile reads flag defaults from a YAML file, which maps command paths to flags and their values, ex.
+//
+//	"*":
+//	  output: yaml
+//	get process:
+//	  namespace: prod
+//	  label: [tier=web, env=prod]
+//
+// the section of a command also applies to its subcommands, and the "*" section applies to every command which has
+// the flag.  a missing file holds no defaults.
+func loadDefaultsFile(file string) (flagDefaults, error) {
+	defaults := flagDefaults{}
+	if file == "" {
+		return defaults, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return defaults, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sections := map[string]map[string]yaml.Node{}
+	err = yaml.Unmarshal(data, &sections)
+	if err != nil {
+		return nil, err
+	}
+
+	for commandPath, section := range sections {
+		commandPath = strings.Join(strings.Fields(commandPath), " ")
+		defaults[commandPath] = map[string][]string{}
+		for name, node := range section {
+			values, err := nodeValues(&node)
+			if err != nil {
+				return nil, fmt.Errorf("flag \"%s\" of \"%s\": %w", name, commandPath, err)
+			}
+			defaults[commandPath][name] = values
+		}
+	}
+
+	return defaults, nil
+}
+
+// nodeValues returns the text of a scalar, or of each scalar of a sequence
+func nodeValues(node *yaml.Node) ([]string, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return []string{node.Value}, nil
+	case yaml.SequenceNode:
+		values := []string{}
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("line %d: expected a list of values", item.Line)
+			}
+			values = append(values, item.Value)
+		}
+		return values, nil
+	}
+
+	return nil, fmt.Errorf("line %d: expected a value or a list of values", node.Line)
+}
+
+// validateDefaults ensures that every section of the defaults file names a command, and that every flag of a section
+// belongs to the command or to one of its parents.  flags are renamed to their long names.
+func (c *Commander) validateDefaults() error {
+	commandPaths := []string{}
+	for commandPath := range c.defaults {
+		commandPaths = append(commandPaths, commandPath)
+	}
+	sort.Strings(commandPaths)
+
+	for _, commandPath := range commandPaths {
+		if commandPath == ALL_COMMANDS {
+			continue
+		}
+
+		tokens := strings.Fields(commandPath)
+		command, parentFlags, remaining := c.LocateCommand(tokens)
+		if command == nil || len(remaining) > 0 {
+			return fmt.Errorf("\"%s\" is not a command", commandPath)
+		}
+
+		flagMap := map[string]*Flag{}
+		for _, flag := range append(parentFlags, command.Flags...) {
+			flagMap[flag.Name] = flag
+			if flag.ShortName != "" {
+				flagMap[flag.ShortName] = flag
+			}
+		}
+
+		section := c.defaults[commandPath]
+		for name, values := range section {
+			flag, ok := flagMap[name]
+			if !ok {
+				return fmt.Errorf("command \"%s\" has no flag \"%s\"", commandPath, name)
+			}
+
+			if len(values) > 1 && !flag.AllowMultiple {
+				return fmt.Errorf("flag %s of \"%s\" does not allow multiple values", flag.GetInvocation(), commandPath)
+			}
+
+			delete(section, name)
+			section[flag.Name] = values
+		}
+	}
+
+	return nil
+}
+
+// lookup returns the default of the named flag for the command path, from the section of the command, else that of
+// the nearest parent command, else the "*" section
+func (d flagDefaults) lookup(commandPath string, flagName string) ([]string, bool) {
+	tokens := strings.Fields(commandPath)
+	for i := len(tokens); i > 0; i-- {
+		if values, ok := d[strings.Join(tokens[:i], " ")][flagName]; ok {
+			return values, true
+		}
+	}
+
+	values, ok := d[ALL_COMMANDS][flagName]
+	return values, ok
+}
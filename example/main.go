@@ -3,7 +3,9 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"os"
 
 	"github.com/hashibuto/commander"
 	"github.com/hashibuto/nilshell/pkg/termutils"
@@ -22,15 +24,17 @@ const (
 	OutputFlag      string = "output"
 )
 
-func getProcesses(outputType string) error {
+func getProcesses(w io.Writer, outputType string) error {
 	if outputType == "table" {
-		commander.Println(
+		commander.Fprintln(
+			w,
 			termutils.PadRight(commander.Sprintf(commander.C_BOLD, "PID"), 12, 2),
 			termutils.PadRight(commander.Sprintf(commander.C_BOLD, "NAME"), 25, 2),
 			commander.Sprintf(commander.C_BOLD, "INVOCATION"),
 		)
 		for _, procObj := range ProcessList {
-			commander.Println(
+			commander.Fprintln(
+				w,
 				termutils.PadRight(fmt.Sprintf("%d", procObj.Id), 12, 2),
 				termutils.PadRight(procObj.Name, 25, 2),
 				procObj.Invocation,
@@ -38,26 +42,28 @@ func getProcesses(outputType string) error {
 		}
 	} else if outputType == "json" {
 		jBytes, _ := json.Marshal(ProcessList)
-		fmt.Println(string(jBytes))
+		fmt.Fprintln(w, string(jBytes))
 	} else {
 		// must be yaml
 		yBytes, _ := yaml.Marshal(ProcessList)
-		fmt.Println(string(yBytes))
+		fmt.Fprintln(w, string(yBytes))
 	}
 
 	return nil
 }
 
-func getProcessGroups(outputType string) error {
+func getProcessGroups(w io.Writer, outputType string) error {
 	if outputType == "table" {
-		commander.Println(
+		commander.Fprintln(
+			w,
 			termutils.PadRight(commander.Sprintf(commander.C_BOLD, "GROUP"), 20, 2),
 			termutils.PadRight(commander.Sprintf(commander.C_BOLD, "PID"), 12, 2),
 			commander.Sprintf(commander.C_BOLD, "PROCESS"),
 		)
 		for _, groupObj := range ProcessGroups {
 			for _, processObj := range groupObj.Processes {
-				commander.Println(
+				commander.Fprintln(
+					w,
 					termutils.PadRight(groupObj.Name, 20, 2),
 					termutils.PadRight(fmt.Sprintf("%d", processObj.Id), 12, 2),
 					processObj.Name,
@@ -66,11 +72,11 @@ func getProcessGroups(outputType string) error {
 		}
 	} else if outputType == "json" {
 		jBytes, _ := json.Marshal(ProcessGroups)
-		fmt.Println(string(jBytes))
+		fmt.Fprintln(w, string(jBytes))
 	} else {
 		// must be yaml
 		yBytes, _ := yaml.Marshal(ProcessGroups)
-		fmt.Println(string(yBytes))
+		fmt.Fprintln(w, string(yBytes))
 	}
 
 	return nil
@@ -102,15 +108,15 @@ func main() {
 						OneOf:        []any{"json", "yaml", "table"},
 					},
 				},
-				OnExecute: func(c *commander.Command, args commander.ArgMap, capturedInput []byte) error {
-					resourceType := ResourceType(args.GetString(ResourceTypeArg))
-					outputType := args[OutputFlag].(string)
+				OnStream: func(ex *commander.Execution) error {
+					resourceType := ResourceType(ex.Args.GetString(ResourceTypeArg))
+					outputType := ex.Args.GetString(OutputFlag)
 
 					var err error
 					if resourceType == Process {
-						err = getProcesses(outputType)
+						err = getProcesses(ex.Stdout, outputType)
 					} else {
-						err = getProcessGroups(outputType)
+						err = getProcessGroups(ex.Stdout, outputType)
 					}
 
 					if err != nil {
@@ -127,7 +133,7 @@ func main() {
 					{
 						Name:        "list",
 						Description: "list processes",
-						OnExecute: func(c *commander.Command, args commander.ArgMap, capturedInput []byte) error {
+						OnStream: func(ex *commander.Execution) error {
 							return nil
 						},
 					},
@@ -141,7 +147,8 @@ func main() {
 
 	err = c.Run()
 	if err != nil {
-		log.Fatal(err)
+		commander.WriteError(os.Stderr, err)
+		os.Exit(commander.ExitCode(err))
 	}
 
 	log.Println("exiting...")
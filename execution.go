@@ -0,0 +1,65 @@
+package commander
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// Streams is the set of streams which a command line reads from and writes to
+type Streams struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Execution describes a single invocation of a command, along with the streams that it reads from and writes to
+type Execution struct {
+	Command *Command
+	Path    string // the tokens which located the command, ex. "get process"
+	Args    ArgMap
+	Sources ValueSources // the source of each value in Args, ex. whether it was given on the command line
+	Stdin   io.Reader    // output of the previous stage in the pipeline, or empty if this is the first stage
+	Stdout  io.Writer    // input of the next stage in the pipeline, or the commander's output if this is the final stage
+	Stderr  io.Writer
+
+	ctx context.Context
+}
+
+// Context returns the context of the execution, which is cancelled when the command is interrupted, times out, or
+// when the downstream stage of the pipeline has exited.
+func (e *Execution) Context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+
+	return e.ctx
+}
+
+// WithContext returns a copy of the execution which uses ctx as its context, for middleware which needs to extend the
+// context of the handlers it wraps
+func (e *Execution) WithContext(ctx context.Context) *Execution {
+	ex := *e
+	ex.ctx = ctx
+	return &ex
+}
+
+// Handler executes a command
+type Handler func(ex *Execution) error
+
+// Middleware wraps a Handler with behavior of its own, such as authorization, logging or timing.  middleware may
+// rewrite the Args of the execution before calling next, or return an error without calling next at all.
+type Middleware func(next Handler) Handler
+
+// syncWriter serializes writes to a writer which is shared by concurrently executing commands
+type syncWriter struct {
+	target io.Writer
+	lock   sync.Mutex
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.target.Write(p)
+}
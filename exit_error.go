@@ -0,0 +1,91 @@
+package commander
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	EXIT_SUCCESS     = 0
+	EXIT_FAILURE     = 1
+	EXIT_USAGE       = 2
+	EXIT_INTERRUPTED = 130
+)
+
+// ExitError is an error which carries an exit status, along with an optional hint on how to resolve it.  Handlers may
+// return an ExitError in order to control the exit status of the command.
+type ExitError struct {
+	Code int
+	Hint string
+	Err  error
+}
+
+// NewExitError returns an ExitError wrapping err with the provided exit status
+func NewExitError(code int, err error) *ExitError {
+	return &ExitError{
+		Code: code,
+		Err:  err,
+	}
+}
+
+func (e *ExitError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("exit status %d", e.Code)
+	}
+
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// usageError returns an ExitError which indicates that a command was invoked incorrectly
+func usageError(err error, hint string) *ExitError {
+	return &ExitError{
+		Code: EXIT_USAGE,
+		Hint: hint,
+		Err:  err,
+	}
+}
+
+// ExitCode returns the exit status represented by err.  nil represents success, an ExitError carries its own status,
+// an interruption is reported as it would be by a posix shell, and any other error is a general failure.
+func ExitCode(err error) int {
+	if err == nil {
+		return EXIT_SUCCESS
+	}
+
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+
+	if errors.Is(err, ErrInterrupted) {
+		return EXIT_INTERRUPTED
+	}
+
+	return EXIT_FAILURE
+}
+
+// WriteError writes the error to w, followed by its hint if it has one
+func WriteError(w io.Writer, err error) {
+	Ferrorln(w, err.Error())
+
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) && exitErr.Hint != "" {
+		Fprintln(w, exitErr.Hint)
+	}
+}
+
+// syntaxUsageError returns err as a usage error, with a hint marking the position of the error within the line if it
+// is a syntax error
+func syntaxUsageError(err error) error {
+	var syntaxErr *SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return usageError(err, syntaxErr.Indicator())
+	}
+
+	return usageError(err, "")
+}
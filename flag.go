@@ -2,6 +2,9 @@ package commander
 
 import (
 	"fmt"
+	"os"
+	"regexp"
+	"strconv"
 	"strings"
 
 	ns "github.com/hashibuto/nilshell"
@@ -17,6 +20,15 @@ type Flag struct {
 	OneOf         []any // if specified, value must belong to collection
 	Completer     Completer
 	IsRequired    bool
+	EnvVar        string // if set, the value is read from this environment variable when the flag isn't given
+
+	// constraints which each value given on the command line must satisfy
+	Min       any            // minimum value of a numeric, DURATION, SIZE or TIME flag
+	Max       any            // maximum value of a numeric, DURATION, SIZE or TIME flag
+	Pattern   *regexp.Regexp // the value, as given on the command line, must match
+	MinLen    int            // minimum length of the value, as given on the command line
+	MaxLen    int            // maximum length of the value, as given on the command line
+	Validator func(value any) error
 }
 
 // Validate returns an error if any part of the flag is invalid
@@ -38,10 +50,22 @@ func (f *Flag) Validate() error {
 		f.ArgType = ArgTypeBool
 	}
 
+	if _, ok := LookupArgType(f.ArgType); !ok {
+		return fmt.Errorf("unknown argument type \"%s\" in %s", f.ArgType, f.GetInvocation())
+	}
+
 	if f.DefaultValue == nil && f.ArgType == ArgTypeBool {
 		f.DefaultValue = false
 	}
 
+	if f.DefaultValue == nil && f.ArgType == ArgTypeCount {
+		f.DefaultValue = 0
+	}
+
+	if f.ArgType == ArgTypeCount && (f.OneOf != nil || f.Completer != nil || f.AllowMultiple) {
+		return fmt.Errorf("OneOf, Completer and AllowMultiple are not compatible with count flags in %s", f.GetInvocation())
+	}
+
 	if f.OneOf != nil && f.ArgType == ArgTypeBool {
 		return fmt.Errorf("OneOf is not compatible with boolean flags in %s", f.GetInvocation())
 	}
@@ -61,15 +85,33 @@ func (f *Flag) Validate() error {
 	}
 
 	for _, oneOf := range f.OneOf {
-		inferredType := InferArgType(oneOf)
-		if inferredType != f.ArgType {
-			return fmt.Errorf("value in OneOf \"%v\" did not match the argument type \"%s\"", oneOf, f.ArgType)
+		err := f.ArgType.validateValue(oneOf)
+		if err != nil {
+			return fmt.Errorf("invalid OneOf in %s: %w", f.GetInvocation(), err)
 		}
 	}
 
+	err := f.constraints().validateConstraints()
+	if err != nil {
+		return fmt.Errorf("invalid constraints in %s: %w", f.GetInvocation(), err)
+	}
+
 	return nil
 }
 
+// constraints returns the constraints which each value of the flag must satisfy
+func (f *Flag) constraints() *valueConstraints {
+	return &valueConstraints{
+		argType:  f.ArgType,
+		min:      f.Min,
+		max:      f.Max,
+		pattern:  f.Pattern,
+		minLen:   f.MinLen,
+		maxLen:   f.MaxLen,
+		validate: f.Validator,
+	}
+}
+
 // GetValueFromString parses the provided value according to the flag's underlying data type and returns that parsed value, or an error
 func (f *Flag) GetValueFromString(value string) (any, error) {
 	return GetValueFromString(f.ArgType, value)
@@ -97,6 +139,84 @@ func (f *Flag) GetPaddedInvocation() string {
 }
 
 func (f *Flag) PopulateMap(value string, target map[string]any) error {
+	parsedValue, err := f.parseValue(value)
+	if err != nil {
+		return fmt.Errorf("invalid value for flag %s: %w", f.GetInvocation(), err)
+	}
+
+	f.store(target, parsedValue)
+	return nil
+}
+
+// takesValue returns true if the flag is given a value, as opposed to a boolean or count flag, which is given alone
+func (f *Flag) takesValue() bool {
+	return f.ArgType != ArgTypeBool && f.ArgType != ArgTypeCount
+}
+
+// isNegatable returns true if the flag may be negated, ex. --no-timestamp.  this applies to every boolean flag other
+// than help.
+func (f *Flag) isNegatable() bool {
+	return f.ArgType == ArgTypeBool && f.Name != "" && f.Name != "help"
+}
+
+// helpInvocation returns the padded invocation of the flag as shown in help, which includes the negated form of the flag
+// if there is one, ex. --[no-]timestamp
+func (f *Flag) helpInvocation() string {
+	invocation := f.GetPaddedInvocation()
+	if f.isNegatable() {
+		return strings.Replace(invocation, "--"+f.Name, "--[no-]"+f.Name, 1)
+	}
+
+	return invocation
+}
+
+// repeatedInvocation returns an example of a count flag given three times
+func (f *Flag) repeatedInvocation() string {
+	if f.ShortName != "" {
+		return fmt.Sprintf("-%s", strings.Repeat(f.ShortName, 3))
+	}
+
+	return strings.TrimSpace(strings.Repeat(fmt.Sprintf("--%s ", f.Name), 3))
+}
+
+// populatePresent populates a flag which was given without a value.  a boolean flag becomes true, regardless of its
+// DefaultValue, and a count flag counts the number of times it was given.
+func (f *Flag) populatePresent(target map[string]any) error {
+	if f.ArgType != ArgTypeCount {
+		return f.PopulateMap("true", target)
+	}
+
+	count := 1
+	if previous, ok := target[f.keys()[0]].(int); ok {
+		count = previous + 1
+	}
+
+	return f.PopulateMap(strconv.Itoa(count), target)
+}
+
+// parseValue parses a value of the flag, given as text, and ensures that it satisfies the flag's OneOf and constraints
+func (f *Flag) parseValue(value string) (any, error) {
+	parsedValue, err := GetValueFromString(f.ArgType, value)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.OneOf != nil {
+		if !MatchesOneOf(f.OneOf, parsedValue) {
+			return nil, fmt.Errorf("\"%s\" does not belong to the collection defined by the flag", FormatValue(parsedValue))
+		}
+	}
+
+	err = f.constraints().check(value, parsedValue)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsedValue, nil
+}
+
+// keys returns the keys under which the value of the flag is stored
+func (f *Flag) keys() []string {
 	keys := []string{}
 	if f.ShortName != "" {
 		keys = append(keys, f.ShortName)
@@ -105,18 +225,13 @@ func (f *Flag) PopulateMap(value string, target map[string]any) error {
 		keys = append(keys, f.Name)
 	}
 
-	for _, key := range keys {
-		parsedValue, err := GetValueFromString(f.ArgType, value)
-		if err != nil {
-			return err
-		}
-
-		if f.OneOf != nil {
-			if !MatchesOneOf(f.OneOf, parsedValue) {
-				return fmt.Errorf("\"%s\" does not belong to the collection defined by the flag", parsedValue)
-			}
-		}
+	return keys
+}
 
+// store stores a parsed value under each of the flag's keys, appending it to any existing values if the flag allows
+// multiple values
+func (f *Flag) store(target map[string]any, parsedValue any) {
+	for _, key := range f.keys() {
 		if f.AllowMultiple {
 			if _, ok := target[key]; !ok {
 				target[key] = []any{}
@@ -127,40 +242,94 @@ func (f *Flag) PopulateMap(value string, target map[string]any) error {
 			target[key] = parsedValue
 		}
 	}
-
-	return nil
 }
 
+// PopulateDefault populates the flag, if it was not given, from its EnvVar, else from its DefaultValue
 func (f *Flag) PopulateDefault(target map[string]any) error {
-	keys := []string{}
-	if f.ShortName != "" {
-		keys = append(keys, f.ShortName)
-	}
-	if f.Name != "" {
-		keys = append(keys, f.Name)
-	}
+	_, err := f.populateDefault(target, nil)
+	return err
+}
 
+// populateDefault populates the flag, if it was not given, from the first of its EnvVar, the defaults file values
+// (if not nil) and its DefaultValue which provides a value.  the source of the value is returned, or ValueSourceNone if
+// the flag was not populated, or has no value.
+func (f *Flag) populateDefault(target map[string]any, fileValues []string) (ValueSource, error) {
+	keys := f.keys()
 	for _, key := range keys {
 		// Skip anything already populated
 		if _, exists := target[key]; exists {
-			continue
+			return ValueSourceNone, nil
 		}
+	}
 
-		if f.DefaultValue == nil && f.IsRequired {
-			return fmt.Errorf("flag %s is required", f.GetInvocation())
+	if text, ok := os.LookupEnv(f.EnvVar); ok && f.EnvVar != "" && text != "" {
+		values := []string{text}
+		if f.AllowMultiple {
+			values = strings.Split(text, ",")
+		}
+		err := f.populateValues(target, values)
+		if err != nil {
+			return ValueSourceNone, fmt.Errorf("invalid value for flag %s from environment variable %s: %w", f.GetInvocation(), f.EnvVar, err)
 		}
+		return ValueSourceEnv, nil
+	}
 
+	if fileValues != nil {
+		err := f.populateValues(target, fileValues)
+		if err != nil {
+			return ValueSourceNone, fmt.Errorf("invalid value for flag %s from the defaults file: %w", f.GetInvocation(), err)
+		}
+		return ValueSourceDefaultsFile, nil
+	}
+
+	if f.DefaultValue == nil && f.IsRequired {
+		return ValueSourceNone, fmt.Errorf("flag %s is required", f.GetInvocation())
+	}
+
+	for _, key := range keys {
 		target[key] = f.DefaultValue
 	}
+	if f.DefaultValue == nil {
+		return ValueSourceNone, nil
+	}
+
+	return ValueSourceDefault, nil
+}
+
+// populateValues parses and stores each of the values, given as text
+func (f *Flag) populateValues(target map[string]any, values []string) error {
+	if len(values) == 0 {
+		for _, key := range f.keys() {
+			target[key] = []any{}
+		}
+	}
+
+	for _, value := range values {
+		parsedValue, err := f.parseValue(strings.TrimSpace(value))
+		if err != nil {
+			return err
+		}
+		f.store(target, parsedValue)
+	}
 
 	return nil
 }
 
+// SuggestValues suggests values of the flag beginning with prefix, omitting any which would violate its constraints
 func (f *Flag) SuggestValues(prefix string) *ns.Suggestions {
+	constraints := f.constraints()
+	if constraints.isEmpty() {
+		return f.suggestValues(prefix)
+	}
+
+	return constraints.filter(f.suggestValues(prefix))
+}
+
+func (f *Flag) suggestValues(prefix string) *ns.Suggestions {
 	if f.OneOf != nil {
 		values := ns.NewSuggestions()
 		for _, oneOf := range f.OneOf {
-			oneOfStr := fmt.Sprintf("%s", oneOf)
+			oneOfStr := FormatValue(oneOf)
 			if strings.HasPrefix(oneOfStr, prefix) {
 				values.Add(ns.NewSuggestion(oneOfStr, oneOfStr))
 			}
@@ -173,5 +342,9 @@ func (f *Flag) SuggestValues(prefix string) *ns.Suggestions {
 		return f.Completer(prefix)
 	}
 
+	if completer := f.ArgType.completer(); completer != nil {
+		return completer(prefix)
+	}
+
 	return nil
 }
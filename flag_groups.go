@@ -0,0 +1,263 @@
+package commander
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// validateFlagGroups ensures that the flag groups of the command refer to flags of the command, or of its parents,
+// by their long names
+func (c *Command) validateFlagGroups(parentFlags map[string]struct{}) error {
+	exists := func(name string) bool {
+		if _, ok := c.flagMap[name]; ok {
+			return true
+		}
+		_, ok := parentFlags[name]
+		return ok
+	}
+
+	groups := map[string][][]string{
+		"MutuallyExclusive": c.MutuallyExclusive,
+		"RequiredTogether":  c.RequiredTogether,
+		"OneRequired":       c.OneRequired,
+	}
+	for label, groupList := range groups {
+		for _, group := range groupList {
+			if len(group) < 2 {
+				return fmt.Errorf("command \"%s\" - %s group %v must name at least 2 flags", c.Name, label, group)
+			}
+			for _, name := range group {
+				if !exists(name) {
+					return fmt.Errorf("command \"%s\" - %s group %v refers to unknown flag \"%s\"", c.Name, label, group, name)
+				}
+			}
+		}
+	}
+
+	for condition, required := range c.RequiredIf {
+		name, _, _ := strings.Cut(condition, "=")
+		if !exists(name) {
+			return fmt.Errorf("command \"%s\" - RequiredIf condition \"%s\" refers to unknown flag \"%s\"", c.Name, condition, name)
+		}
+		for _, requiredName := range required {
+			if !exists(requiredName) {
+				return fmt.Errorf("command \"%s\" - RequiredIf condition \"%s\" requires unknown flag \"%s\"", c.Name, condition, requiredName)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkFlagGroups returns an error describing the first flag group which the classified flags violate.  a flag counts
+// as given when it was supplied by the command line, its EnvVar or the defaults file, but not by its DefaultValue.  as
+// the command line takes precedence over the fallbacks, a flag given on the command line discards any fallback values of
+// the flags it is mutually exclusive with, while fallback values which exclude one another are an error.
+func (c *Command) checkFlagGroups(args ArgMap, sources ValueSources, allFlagMap map[string]*Flag) error {
+	for _, group := range c.MutuallyExclusive {
+		explicit := explicitFlags(sources, group)
+		if len(explicit) > 1 {
+			return fmt.Errorf("command \"%s\" - flags %s cannot be used together", c.Name, joinFlagNames(explicit, "and"))
+		}
+
+		given := givenFlags(sources, group)
+		if len(explicit) == 0 && len(given) > 1 {
+			return fmt.Errorf("command \"%s\" - flags %s cannot be used together, but are given by %s", c.Name, joinFlagNames(given, "and"), describeSources(sources, given))
+		}
+
+		for _, name := range given {
+			if len(explicit) == 1 && name != explicit[0] {
+				discardFallback(args, sources, allFlagMap[name])
+			}
+		}
+	}
+
+	for _, group := range c.RequiredTogether {
+		given := givenFlags(sources, group)
+		if len(given) > 0 && len(given) < len(group) {
+			return fmt.Errorf("command \"%s\" - flags %s must be used together", c.Name, joinFlagNames(group, "and"))
+		}
+	}
+
+	for _, group := range c.OneRequired {
+		if len(givenFlags(sources, group)) == 0 {
+			return fmt.Errorf("command \"%s\" - one of the flags %s is required", c.Name, joinFlagNames(group, "or"))
+		}
+	}
+
+	for _, condition := range sortedConditions(c.RequiredIf) {
+		if !conditionHolds(args, sources, condition) {
+			continue
+		}
+		for _, name := range c.RequiredIf[condition] {
+			if !isGiven(sources, name) {
+				return fmt.Errorf("command \"%s\" - flag --%s is required when %s", c.Name, name, describeCondition(condition))
+			}
+		}
+	}
+
+	return nil
+}
+
+// describeFlagGroups returns a line describing each flag group, for help
+func (c *Command) describeFlagGroups() []string {
+	lines := []string{}
+	for _, group := range c.MutuallyExclusive {
+		lines = append(lines, fmt.Sprintf("%s cannot be used together", joinFlagNames(group, "and")))
+	}
+	for _, group := range c.RequiredTogether {
+		lines = append(lines, fmt.Sprintf("%s must be used together", joinFlagNames(group, "and")))
+	}
+	for _, group := range c.OneRequired {
+		lines = append(lines, fmt.Sprintf("one of %s is required", joinFlagNames(group, "or")))
+	}
+	for _, condition := range sortedConditions(c.RequiredIf) {
+		required := c.RequiredIf[condition]
+		verb := "is"
+		if len(required) > 1 {
+			verb = "are"
+		}
+		lines = append(lines, fmt.Sprintf("%s %s required when %s", joinFlagNames(required, "and"), verb, describeCondition(condition)))
+	}
+
+	return lines
+}
+
+// excludes returns true if the named flag belongs to a mutually exclusive group together with one of the used flags
+func (c *Command) excludes(used map[string]bool, name string) bool {
+	for _, group := range c.MutuallyExclusive {
+		member := false
+		for _, groupName := range group {
+			member = member || groupName == name
+		}
+		if !member {
+			continue
+		}
+
+		for _, groupName := range group {
+			if groupName != name && used[groupName] {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// givenFlags returns the names within the group of the flags which were given (see isGiven)
+func givenFlags(sources ValueSources, group []string) []string {
+	given := []string{}
+	for _, name := range group {
+		if isGiven(sources, name) {
+			given = append(given, name)
+		}
+	}
+
+	return given
+}
+
+// explicitFlags returns the names within the group of the flags which were given on the command line
+func explicitFlags(sources ValueSources, group []string) []string {
+	explicit := []string{}
+	for _, name := range group {
+		if sources.WasSet(name) {
+			explicit = append(explicit, name)
+		}
+	}
+
+	return explicit
+}
+
+// isGiven returns true if the named flag was supplied by the command line, its EnvVar or the defaults file, rather than
+// holding its DefaultValue or no value at all
+func isGiven(sources ValueSources, name string) bool {
+	source := sources.Source(name)
+	return source != ValueSourceNone && source != ValueSourceDefault
+}
+
+// discardFallback restores the DefaultValue of a flag which was populated from its EnvVar or the defaults file
+func discardFallback(args ArgMap, sources ValueSources, flag *Flag) {
+	for _, key := range flag.keys() {
+		args[key] = flag.DefaultValue
+		delete(sources, key)
+	}
+	if flag.DefaultValue != nil {
+		sources.set(ValueSourceDefault, flag.keys()...)
+	}
+}
+
+// describeSources describes where the values of the named flags came from, ex. "the environment and the defaults file"
+func describeSources(sources ValueSources, names []string) string {
+	described := []string{}
+	for _, name := range names {
+		description := fmt.Sprintf("the %s", sources.Source(name))
+		if !slices.Contains(described, description) {
+			described = append(described, description)
+		}
+	}
+
+	return strings.Join(described, " and ")
+}
+
+// conditionHolds returns true if the flag of the condition ("flag=value") holds the value, or, for a condition which
+// names only a flag, if the flag was given (see isGiven)
+func conditionHolds(args ArgMap, sources ValueSources, condition string) bool {
+	name, expected, hasValue := strings.Cut(condition, "=")
+	if !hasValue {
+		return isGiven(sources, name)
+	}
+
+	value, ok := args.Lookup(name)
+	if !ok {
+		return false
+	}
+
+	values, ok := value.([]any)
+	if !ok {
+		values = []any{value}
+	}
+	for _, v := range values {
+		if FormatValue(v) == expected {
+			return true
+		}
+	}
+
+	return false
+}
+
+// describeCondition describes a condition of RequiredIf
+func describeCondition(condition string) string {
+	name, value, hasValue := strings.Cut(condition, "=")
+	if !hasValue {
+		return fmt.Sprintf("--%s is given", name)
+	}
+
+	return fmt.Sprintf("--%s is %s", name, value)
+}
+
+// sortedConditions returns the conditions of RequiredIf in a stable order
+func sortedConditions(requiredIf map[string][]string) []string {
+	conditions := []string{}
+	for condition := range requiredIf {
+		conditions = append(conditions, condition)
+	}
+	sort.Strings(conditions)
+
+	return conditions
+}
+
+// joinFlagNames joins the flag names as they are invoked, ex. "--all and --name"
+func joinFlagNames(names []string, conjunction string) string {
+	invocations := []string{}
+	for _, name := range names {
+		invocations = append(invocations, fmt.Sprintf("--%s", name))
+	}
+
+	if len(invocations) == 1 {
+		return invocations[0]
+	}
+
+	return fmt.Sprintf("%s %s %s", strings.Join(invocations[:len(invocations)-1], ", "), conjunction, invocations[len(invocations)-1])
+}
@@ -0,0 +1,398 @@
+package commander
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	ns "github.com/hashibuto/nilshell"
+)
+
+const (
+	DEFAULT_HISTORY_SIZE = 1000
+)
+
+// History is the line history of the interactive shell.  when backed by a file, lines are appended to the file as
+// they are entered, tagged with the namespace of the history, such that several applications can share a single file
+// without their lines mixing.
+type History struct {
+	file      string
+	size      int
+	namespace string
+
+	lock    sync.Mutex
+	entries []string
+	// pending maps a line, as it will be pushed by the shell, to the line it expanded to, which is recorded instead
+	pending map[string]string
+}
+
+// historyEntry is a single line of a history file
+type historyEntry struct {
+	Namespace string `json:"namespace,omitempty"`
+	Line      string `json:"line"`
+}
+
+// historyIterator steps through a snapshot of the history entries, from the most recent entry backward
+type historyIterator struct {
+	entries []string
+	index   int
+}
+
+// NewHistory returns a new History retaining up to size entries, which is loaded from file if the file is not empty
+func NewHistory(file string, size int, namespace string) (*History, error) {
+	if size <= 0 {
+		size = DEFAULT_HISTORY_SIZE
+	}
+
+	h := &History{
+		file:      file,
+		size:      size,
+		namespace: namespace,
+		entries:   []string{},
+		pending:   map[string]string{},
+	}
+
+	if file != "" {
+		err := h.load()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return h, nil
+}
+
+// Entries returns all history entries, oldest first.  entries are numbered from 1 in this order.
+func (h *History) Entries() []string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return append([]string{}, h.entries...)
+}
+
+// Push records a line in the history, unless it repeats the most recent entry
+func (h *History) Push(line string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if expanded, ok := h.pending[line]; ok {
+		line = expanded
+	}
+	clear(h.pending)
+
+	if len(h.entries) > 0 && h.entries[len(h.entries)-1] == line {
+		return
+	}
+
+	h.entries = append(h.entries, line)
+	if len(h.entries) > h.size {
+		h.entries = h.entries[len(h.entries)-h.size:]
+	}
+
+	// a line which can't be saved is still retained for the remainder of the session
+	h.append(line)
+}
+
+// Search returns the entries containing the pattern, most recent first
+func (h *History) Search(pattern string) []string {
+	if len(pattern) == 0 {
+		return nil
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	results := []string{}
+	seen := map[string]struct{}{}
+	for i := len(h.entries) - 1; i >= 0; i-- {
+		entry := h.entries[i]
+		if _, exists := seen[entry]; exists || !strings.Contains(entry, pattern) {
+			continue
+		}
+		seen[entry] = struct{}{}
+		results = append(results, entry)
+	}
+
+	return results
+}
+
+// Clear removes all entries from the history, including those saved to the history file
+func (h *History) Clear() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.entries = []string{}
+	if h.file == "" {
+		return nil
+	}
+
+	return h.rewrite()
+}
+
+// GetIterator returns an iterator over the current entries, for use by the shell
+func (h *History) GetIterator() ns.HistoryIterator {
+	entries := h.Entries()
+	return &historyIterator{
+		entries: entries,
+		index:   len(entries),
+	}
+}
+
+// Exit is called by the shell when it exits.  lines are saved as they are pushed, so there is nothing left to do.
+func (h *History) Exit() {
+}
+
+// Expand replaces the history references within the line (!! for the previous line, !n for line n, !-n for the nth
+// previous line, and !prefix for the most recent line beginning with prefix) with the lines they refer to.  references
+// within single quotes, or preceded by a backslash, are not expanded.
+func (h *History) Expand(line string) (string, error) {
+	entries := h.Entries()
+	expanded := strings.Builder{}
+	var quote byte = 0
+	for i := 0; i < len(line); i++ {
+		char := line[i]
+		switch {
+		case char == '\\' && quote != '\'' && i+1 < len(line):
+			expanded.WriteString(line[i : i+2])
+			i++
+			continue
+		case char == '\'' && quote != '"':
+			quote ^= '\''
+		case char == '"' && quote != '\'':
+			quote ^= '"'
+		case char == '!' && quote != '\'':
+			reference := parseHistoryReference(line[i:])
+			if reference == "" {
+				break
+			}
+
+			entry, ok := findHistoryEntry(entries, reference[1:])
+			if !ok {
+				return "", fmt.Errorf("%s: event not found", reference)
+			}
+			expanded.WriteString(entry)
+			i += len(reference) - 1
+			continue
+		}
+
+		expanded.WriteByte(char)
+	}
+
+	return expanded.String(), nil
+}
+
+// expandLine expands the history references within a line entered at the shell, and arranges for the expanded line to
+// be recorded in the history in place of the line as it was entered
+func (h *History) expandLine(line string) (string, error) {
+	expanded, err := h.Expand(line)
+	if err != nil {
+		return "", err
+	}
+
+	if expanded != line {
+		h.lock.Lock()
+		h.pending[line] = expanded
+		h.lock.Unlock()
+	}
+
+	return expanded, nil
+}
+
+// parseHistoryReference returns the history reference at the start of text, or an empty string if the ! at the start
+// of text is taken literally
+func parseHistoryReference(text string) string {
+	if strings.HasPrefix(text, "!!") {
+		return "!!"
+	}
+
+	length := 1
+	for length < len(text) && !strings.ContainsRune(QUOTED_CHARS+"!=", rune(text[length])) {
+		length++
+	}
+
+	if length == 1 {
+		return ""
+	}
+
+	return text[:length]
+}
+
+// findHistoryEntry locates the entry described by the reference, which excludes the leading !
+func findHistoryEntry(entries []string, reference string) (string, bool) {
+	if reference == "!" {
+		reference = "-1"
+	}
+
+	if number, err := strconv.Atoi(reference); err == nil {
+		if number < 0 {
+			number = len(entries) + number + 1
+		}
+		if number < 1 || number > len(entries) {
+			return "", false
+		}
+		return entries[number-1], true
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		if strings.HasPrefix(entries[i], reference) {
+			return entries[i], true
+		}
+	}
+
+	return "", false
+}
+
+// load reads the entries belonging to the namespace from the history file, and compacts the file if the namespace
+// holds more entries than are retained
+func (h *History) load() error {
+	lock := ns.NewFileLock(h.file + ".lock")
+	err := lock.Lock()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	entries, err := h.readFile()
+	if err != nil {
+		return err
+	}
+
+	total := 0
+	for _, entry := range entries {
+		if entry.Namespace == h.namespace {
+			h.entries = append(h.entries, entry.Line)
+			total++
+		}
+	}
+
+	if total > h.size {
+		h.entries = h.entries[total-h.size:]
+		return h.writeFile(entries)
+	}
+
+	return nil
+}
+
+// append adds a single line to the history file
+func (h *History) append(line string) error {
+	if h.file == "" {
+		return nil
+	}
+
+	lock := ns.NewFileLock(h.file + ".lock")
+	err := lock.Lock()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	data, err := json.Marshal(historyEntry{Namespace: h.namespace, Line: line})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(h.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// rewrite replaces the entries of the namespace within the history file with the current entries
+func (h *History) rewrite() error {
+	lock := ns.NewFileLock(h.file + ".lock")
+	err := lock.Lock()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	entries, err := h.readFile()
+	if err != nil {
+		return err
+	}
+
+	return h.writeFile(entries)
+}
+
+// readFile reads every entry of the history file, regardless of namespace
+func (h *History) readFile() ([]*historyEntry, error) {
+	f, err := os.Open(h.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []*historyEntry{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, MAX_LINE_BUFFER), MAX_LINE_BUFFER)
+	for scanner.Scan() {
+		entry := &historyEntry{}
+		if json.Unmarshal(scanner.Bytes(), entry) == nil {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, scanner.Err()
+}
+
+// writeFile writes the entries of other namespaces, followed by the current entries of this namespace, to the history
+// file
+func (h *History) writeFile(entries []*historyEntry) error {
+	lines := []byte{}
+	for _, entry := range entries {
+		if entry.Namespace == h.namespace {
+			continue
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, data...), '\n')
+	}
+
+	for _, line := range h.entries {
+		data, err := json.Marshal(historyEntry{Namespace: h.namespace, Line: line})
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, data...), '\n')
+	}
+
+	return os.WriteFile(h.file, lines, 0644)
+}
+
+func (i *historyIterator) Backward() string {
+	if len(i.entries) == 0 {
+		return ""
+	}
+
+	if i.index > 0 {
+		i.index--
+	}
+
+	return i.entries[i.index]
+}
+
+func (i *historyIterator) Forward() string {
+	if i.index < len(i.entries) {
+		i.index++
+	}
+
+	if i.index == len(i.entries) {
+		return ""
+	}
+
+	return i.entries[i.index]
+}
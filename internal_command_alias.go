@@ -0,0 +1,38 @@
+package commander
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	DefinitionArg string = "definition"
+)
+
+var AliasCommand = &Command{
+	Name:        "alias",
+	Description: "define command aliases",
+	Arguments: []*Argument{
+		{
+			Name:          DefinitionArg,
+			Description:   "alias definition in the form of name=value",
+			ArgType:       ArgTypeString,
+			AllowMultiple: true,
+		},
+	},
+	OnStream: func(ex *Execution) error {
+		for _, definition := range ex.Args.GetStringArray(DefinitionArg) {
+			name, value, ok := strings.Cut(definition, "=")
+			if !ok {
+				return fmt.Errorf("alias \"%s\" must be in the form of name=value", definition)
+			}
+
+			err := ex.Command.Commander.SetAlias(name, value)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+}
@@ -1,13 +1,16 @@
 package commander
 
-import "github.com/hashibuto/nilshell/pkg/termutils"
+import (
+	"fmt"
+
+	"github.com/hashibuto/nilshell/pkg/termutils"
+)
 
 var ClearCommand = &Command{
 	Name:        "clear",
 	Description: "clear the terminal",
-	OnExecute: func(c *Command, args ArgMap, capturedInput []byte) error {
-		termutils.ClearTerminal()
-		termutils.SetCursorPos(1, 1)
-		return nil
+	OnStream: func(ex *Execution) error {
+		_, err := fmt.Fprintf(ex.Stdout, "%s\x1b[1;1H", termutils.TERM_CLEAR)
+		return err
 	},
 }
@@ -0,0 +1,55 @@
+package commander
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	AllArg string = "all"
+)
+
+var EnvCommand = &Command{
+	Name:        "env",
+	Description: "list session variables",
+	Flags: []*Flag{
+		{
+			Name:         AllArg,
+			ShortName:    "a",
+			Description:  "include environment variables",
+			ArgType:      ArgTypeBool,
+			DefaultValue: false,
+		},
+	},
+	OnStream: func(ex *Execution) error {
+		variables := map[string]string{}
+		if ex.Args.GetBool(AllArg) {
+			for _, env := range os.Environ() {
+				name, value, _ := strings.Cut(env, "=")
+				variables[name] = value
+			}
+		}
+
+		// session variables take precedence over the environment
+		for name, value := range ex.Command.Commander.Variables() {
+			variables[name] = value
+		}
+
+		names := []string{}
+		for name := range variables {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			_, err := fmt.Fprintf(ex.Stdout, "%s=%s\n", name, variables[name])
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+}
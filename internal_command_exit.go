@@ -7,7 +7,7 @@ import (
 var ExitCommand = &Command{
 	Name:        "exit",
 	Description: "exit the shell",
-	OnExecute: func(c *Command, args ArgMap, capturedInput []byte) error {
+	OnStream: func(ex *Execution) error {
 		return ns.ErrEof
 	},
 }
@@ -0,0 +1,33 @@
+package commander
+
+import "fmt"
+
+const (
+	JobArg string = "job"
+)
+
+var FgCommand = &Command{
+	Name:        "fg",
+	Description: "bring a background job to the foreground",
+	Arguments: []*Argument{
+		{
+			Name:        JobArg,
+			Description: "id of the job",
+			ArgType:     ArgTypeInt,
+		},
+	},
+	OnStream: func(ex *Execution) error {
+		if isBackground(ex.Context()) {
+			return fmt.Errorf("jobs can't be brought to the foreground from a background job")
+		}
+
+		c := ex.Command.Commander
+		job, ok := c.GetJob(ex.Args.GetInt(JobArg))
+		if !ok {
+			return fmt.Errorf("no such job %d", ex.Args.GetInt(JobArg))
+		}
+
+		ex.Println(job.Line)
+		return c.foregroundJob(ex.Context(), job, Streams{Stdout: ex.Stdout, Stderr: ex.Stderr})
+	},
+}
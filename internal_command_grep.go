@@ -1,7 +1,9 @@
 package commander
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"strings"
 )
 
@@ -29,27 +31,38 @@ var GrepCommand = &Command{
 			DefaultValue: false,
 		},
 	},
-	OnExecute: func(c *Command, args ArgMap, capturedInput []byte) error {
-		pattern := args.GetString(PatternArg)
-		insensitive := args.GetBool(InsensitiveArg)
+	OnStream: func(ex *Execution) error {
+		pattern := ex.Args.GetString(PatternArg)
+		insensitive := ex.Args.GetBool(InsensitiveArg)
 		lowerPattern := strings.ToLower(pattern)
 
-		if len(capturedInput) > 0 {
-			ci := string(capturedInput)
-			lines := strings.Split(ci, "\n")
-			for _, line := range lines {
-				if insensitive {
-					if !strings.Contains(strings.ToLower(line), lowerPattern) {
-						continue
-					}
-				} else if !strings.Contains(line, pattern) {
-					continue
+		// lines are read whole, however long they may be
+		reader := bufio.NewReader(ex.Stdin)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil && err != io.EOF {
+				return err
+			}
+			if line == "" && err == io.EOF {
+				return nil
+			}
+
+			line = strings.TrimSuffix(line, "\n")
+			matched := strings.Contains(line, pattern)
+			if insensitive {
+				matched = strings.Contains(strings.ToLower(line), lowerPattern)
+			}
+
+			if matched {
+				_, writeErr := fmt.Fprintln(ex.Stdout, line)
+				if writeErr != nil {
+					return writeErr
 				}
+			}
 
-				fmt.Println(line)
+			if err == io.EOF {
+				return nil
 			}
 		}
-
-		return nil
 	},
 }
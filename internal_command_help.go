@@ -8,8 +8,9 @@ import (
 var HelpCommand = &Command{
 	Name:        "help",
 	Description: "display contextual command help",
-	OnExecute: func(c *Command, args ArgMap, capturedInput []byte) error {
-		fmt.Println("Command list:")
+	OnStream: func(ex *Execution) error {
+		c := ex.Command
+		fmt.Fprintln(ex.Stdout, "Command list:")
 
 		commandList := []string{}
 		for _, cmd := range c.Commander.commandMap {
@@ -21,7 +22,16 @@ var HelpCommand = &Command{
 
 		for _, cmdName := range commandList {
 			cmd := c.Commander.commandMap[cmdName]
-			fmt.Printf("  %s%s\n", PadRight(cmd.Name, COMMAND_PADDING), cmd.Description)
+			fmt.Fprintf(ex.Stdout, "  %s%s\n", PadRight(cmd.Name, COMMAND_PADDING), cmd.Description)
+		}
+
+		aliasNames := c.Commander.aliasNames()
+		if len(aliasNames) > 0 {
+			fmt.Fprintln(ex.Stdout, "\nAlias list:")
+			for _, name := range aliasNames {
+				value, _ := c.Commander.GetAlias(name)
+				fmt.Fprintf(ex.Stdout, "  %s%s\n", PadRight(name, COMMAND_PADDING), value)
+			}
 		}
 
 		return nil
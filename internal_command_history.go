@@ -0,0 +1,52 @@
+package commander
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	SearchArg string = "search"
+	ClearArg  string = "clear"
+)
+
+var HistoryCommand = &Command{
+	Name:        "history",
+	Description: "list, search or clear the shell history",
+	Flags: []*Flag{
+		{
+			Name:        SearchArg,
+			ShortName:   "s",
+			Description: "only list entries containing this text",
+			ArgType:     ArgTypeString,
+		},
+		{
+			Name:         ClearArg,
+			ShortName:    "c",
+			Description:  "remove all entries",
+			ArgType:      ArgTypeBool,
+			DefaultValue: false,
+		},
+	},
+	OnStream: func(ex *Execution) error {
+		history := ex.Command.Commander.History()
+		if ex.Args.GetBool(ClearArg) {
+			return history.Clear()
+		}
+
+		// entries keep their number when searching, so that they can be recalled with !n
+		search := ex.Args.GetString(SearchArg)
+		for i, entry := range history.Entries() {
+			if search != "" && !strings.Contains(entry, search) {
+				continue
+			}
+
+			_, err := fmt.Fprintf(ex.Stdout, "%5d  %s\n", i+1, entry)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+}
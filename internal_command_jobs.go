@@ -0,0 +1,22 @@
+package commander
+
+import (
+	"fmt"
+	"time"
+)
+
+var JobsCommand = &Command{
+	Name:        "jobs",
+	Description: "list background jobs",
+	OnStream: func(ex *Execution) error {
+		for _, job := range ex.Command.Commander.Jobs() {
+			elapsed := time.Since(job.StartedAt).Round(time.Second)
+			_, err := fmt.Fprintf(ex.Stdout, "[%d]  %s%s%s\n", job.Id, PadRight(string(job.State()), 10), PadRight(elapsed.String(), 10), job.Line)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+}
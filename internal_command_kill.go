@@ -0,0 +1,28 @@
+package commander
+
+import "fmt"
+
+var KillCommand = &Command{
+	Name:        "kill",
+	Description: "cancel background jobs",
+	Arguments: []*Argument{
+		{
+			Name:          JobArg,
+			Description:   "id of the job",
+			ArgType:       ArgTypeInt,
+			AllowMultiple: true,
+		},
+	},
+	OnStream: func(ex *Execution) error {
+		c := ex.Command.Commander
+		for _, id := range ex.Args.GetIntArray(JobArg) {
+			job, ok := c.GetJob(id)
+			if !ok {
+				return fmt.Errorf("no such job %d", id)
+			}
+			job.Kill()
+		}
+
+		return nil
+	},
+}
@@ -0,0 +1,39 @@
+package commander
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	AssignmentArg string = "assignment"
+)
+
+var SetCommand = &Command{
+	Name:        "set",
+	Description: "set session variables",
+	Arguments: []*Argument{
+		{
+			Name:          AssignmentArg,
+			Description:   "variable assignment in the form of name=value",
+			ArgType:       ArgTypeString,
+			AllowMultiple: true,
+		},
+	},
+	OnStream: func(ex *Execution) error {
+		assignments := ex.Args.GetStringArray(AssignmentArg)
+		for _, assignment := range assignments {
+			name, value, ok := strings.Cut(assignment, "=")
+			if !ok {
+				return fmt.Errorf("assignment \"%s\" must be in the form of name=value", assignment)
+			}
+
+			err := ex.Command.Commander.SetVariable(name, value)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+}
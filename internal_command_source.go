@@ -0,0 +1,48 @@
+package commander
+
+import (
+	"os"
+)
+
+const (
+	FileArg     string = "file"
+	ContinueArg string = "continue"
+)
+
+var SourceCommand = &Command{
+	Name:        "source",
+	Description: "execute commands from a file",
+	Arguments: []*Argument{
+		{
+			Name:        FileArg,
+			Description: "path of the script file",
+			ArgType:     ArgTypeString,
+		},
+	},
+	Flags: []*Flag{
+		{
+			Name:         ContinueArg,
+			ShortName:    "c",
+			Description:  "continue executing after a line fails",
+			ArgType:      ArgTypeBool,
+			DefaultValue: false,
+		},
+	},
+	OnStream: func(ex *Execution) error {
+		path := ex.Args.GetString(FileArg)
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		// script output is written to the streams of this execution, such that sourcing can participate in a pipeline
+		return ex.Command.Commander.runScript(ex.Context(), f, ScriptConfig{
+			Name:        path,
+			StopOnError: !ex.Args.GetBool(ContinueArg),
+		}, Streams{
+			Stdout: ex.Stdout,
+			Stderr: ex.Stderr,
+		})
+	},
+}
@@ -0,0 +1,28 @@
+package commander
+
+const (
+	AliasArg string = "alias"
+)
+
+var UnaliasCommand = &Command{
+	Name:        "unalias",
+	Description: "remove command aliases",
+	Arguments: []*Argument{
+		{
+			Name:          AliasArg,
+			Description:   "name of the alias",
+			ArgType:       ArgTypeString,
+			AllowMultiple: true,
+		},
+	},
+	OnStream: func(ex *Execution) error {
+		for _, name := range ex.Args.GetStringArray(AliasArg) {
+			err := ex.Command.Commander.RemoveAlias(name)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+}
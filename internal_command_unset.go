@@ -0,0 +1,25 @@
+package commander
+
+const (
+	VariableArg string = "variable"
+)
+
+var UnsetCommand = &Command{
+	Name:        "unset",
+	Description: "remove session variables",
+	Arguments: []*Argument{
+		{
+			Name:          VariableArg,
+			Description:   "name of the variable",
+			ArgType:       ArgTypeString,
+			AllowMultiple: true,
+		},
+	},
+	OnStream: func(ex *Execution) error {
+		for _, name := range ex.Args.GetStringArray(VariableArg) {
+			ex.Command.Commander.UnsetVariable(name)
+		}
+
+		return nil
+	},
+}
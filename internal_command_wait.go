@@ -0,0 +1,22 @@
+package commander
+
+var WaitCommand = &Command{
+	Name:        "wait",
+	Description: "wait for all background jobs to complete",
+	OnStream: func(ex *Execution) error {
+		self := contextJob(ex.Context())
+		for _, job := range ex.Command.Commander.Jobs() {
+			if job == self {
+				continue
+			}
+
+			select {
+			case <-job.Done():
+			case <-ex.Context().Done():
+				return ex.Context().Err()
+			}
+		}
+
+		return nil
+	},
+}
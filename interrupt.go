@@ -0,0 +1,35 @@
+package commander
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+)
+
+var (
+	ErrInterrupted = errors.New("interrupted")
+)
+
+// withInterrupt returns a context which is cancelled with ErrInterrupted as its cause, when the process receives an
+// interrupt signal (ctrl+c).  the returned function must be called to stop listening for the signal.
+func withInterrupt(parent context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(parent)
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt)
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-sigChan:
+			cancel(ErrInterrupted)
+		case <-done:
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(sigChan)
+		close(done)
+		cancel(nil)
+	}
+}
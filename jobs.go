@@ -0,0 +1,261 @@
+package commander
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+type JobState string
+
+const (
+	MAX_JOB_BUFFER = 1 << 20 // bytes of a background job's output held in memory, per stream, before using a file
+)
+
+const (
+	JobStateRunning JobState = "running"
+	JobStateDone    JobState = "done"
+	JobStateFailed  JobState = "failed"
+)
+
+var (
+	ErrJobKilled = errors.New("killed")
+)
+
+type backgroundKey struct{}
+
+// Job is a command line which executes in the background
+type Job struct {
+	Id        int
+	Line      string
+	StartedAt time.Time
+
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+	err    error
+	stdout *jobOutput
+	stderr *jobOutput
+}
+
+// State returns the current state of the job
+func (j *Job) State() JobState {
+	select {
+	case <-j.done:
+		if j.err != nil {
+			return JobStateFailed
+		}
+		return JobStateDone
+	default:
+		return JobStateRunning
+	}
+}
+
+// Wait blocks until the job completes, returning its error
+func (j *Job) Wait() error {
+	<-j.done
+	return j.err
+}
+
+// Done returns a channel which is closed once the job completes
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Kill cancels the job
+func (j *Job) Kill() {
+	j.cancel(ErrJobKilled)
+}
+
+// jobOutput holds the output of a job until it is attached to a writer, at which point the held output is written and
+// any further output is written directly.  output beyond MAX_JOB_BUFFER is held in a temporary file rather than in
+// memory.
+type jobOutput struct {
+	lock   sync.Mutex
+	buffer bytes.Buffer
+	spill  *os.File
+	target io.Writer
+}
+
+func (o *jobOutput) Write(p []byte) (int, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if o.target != nil {
+		return o.target.Write(p)
+	}
+
+	if o.spill == nil && o.buffer.Len()+len(p) > MAX_JOB_BUFFER {
+		f, err := os.CreateTemp("", "commander-job-*")
+		if err != nil {
+			return 0, fmt.Errorf("unable to hold the output of a background job: %w", err)
+		}
+		o.spill = f
+		_, err = o.buffer.WriteTo(f)
+		if err != nil {
+			return 0, fmt.Errorf("unable to hold the output of a background job: %w", err)
+		}
+	}
+
+	if o.spill != nil {
+		return o.spill.Write(p)
+	}
+
+	return o.buffer.Write(p)
+}
+
+func (o *jobOutput) attach(target io.Writer) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if o.spill != nil {
+		_, err := o.spill.Seek(0, io.SeekStart)
+		if err == nil {
+			io.Copy(target, o.spill)
+		}
+		o.spill.Close()
+		os.Remove(o.spill.Name())
+		o.spill = nil
+	}
+
+	target.Write(o.buffer.Bytes())
+	o.buffer.Reset()
+	o.target = target
+}
+
+func (o *jobOutput) detach() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.target = nil
+}
+
+// isBackground returns true if the context belongs to a background job
+func isBackground(ctx context.Context) bool {
+	return contextJob(ctx) != nil
+}
+
+// contextJob returns the background job to which the context belongs, if any
+func contextJob(ctx context.Context) *Job {
+	job, _ := ctx.Value(backgroundKey{}).(*Job)
+	return job
+}
+
+// startJob executes fn in the background as a new job.  the job is not interrupted by ctrl+c, and its output is held
+// until it is brought to the foreground, or until it completes.
+func (c *Commander) startJob(ctx context.Context, line string, fn func(ctx context.Context, streams Streams) error) *Job {
+	job := &Job{
+		Line:      line,
+		StartedAt: time.Now(),
+		done:      make(chan struct{}),
+		stdout:    &jobOutput{},
+		stderr:    &jobOutput{},
+	}
+
+	ctx = context.WithValue(context.WithoutCancel(ctx), backgroundKey{}, job)
+	ctx, job.cancel = context.WithCancelCause(ctx)
+
+	c.jobLock.Lock()
+	job.Id = 1
+	for {
+		if _, exists := c.jobs[job.Id]; !exists {
+			break
+		}
+		job.Id++
+	}
+	c.jobs[job.Id] = job
+	c.jobLock.Unlock()
+
+	go func() {
+		err := fn(ctx, Streams{
+			Stdout: job.stdout,
+			Stderr: job.stderr,
+		})
+		if err != nil && ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+			err = context.Cause(ctx)
+		}
+		job.err = err
+		job.cancel(nil)
+		close(job.done)
+	}()
+
+	return job
+}
+
+// GetJob returns the job with the provided id
+func (c *Commander) GetJob(id int) (*Job, bool) {
+	c.jobLock.Lock()
+	defer c.jobLock.Unlock()
+
+	job, ok := c.jobs[id]
+	return job, ok
+}
+
+// Jobs returns all jobs which have yet to be reported as complete, ordered by id
+func (c *Commander) Jobs() []*Job {
+	c.jobLock.Lock()
+	defer c.jobLock.Unlock()
+
+	jobs := []*Job{}
+	for _, job := range c.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Id < jobs[j].Id
+	})
+
+	return jobs
+}
+
+// removeJob removes the job from the job table
+func (c *Commander) removeJob(job *Job) {
+	c.jobLock.Lock()
+	defer c.jobLock.Unlock()
+
+	delete(c.jobs, job.Id)
+}
+
+// foregroundJob attaches the job's output to the streams, and waits for it to complete.  if the context is cancelled
+// first, the job is cancelled along with it.
+func (c *Commander) foregroundJob(ctx context.Context, job *Job, streams Streams) error {
+	job.stdout.attach(streams.Stdout)
+	job.stderr.attach(streams.Stderr)
+	defer job.stdout.detach()
+	defer job.stderr.detach()
+
+	select {
+	case <-job.done:
+	case <-ctx.Done():
+		job.cancel(context.Cause(ctx))
+		<-job.done
+	}
+
+	c.removeJob(job)
+	return job.err
+}
+
+// reportJobs writes the held output of every completed job, followed by a notice of its completion, and removes it
+// from the job table
+func (c *Commander) reportJobs(streams Streams) {
+	for _, job := range c.Jobs() {
+		state := job.State()
+		if state == JobStateRunning {
+			continue
+		}
+
+		c.removeJob(job)
+		job.stdout.attach(streams.Stdout)
+		job.stderr.attach(streams.Stderr)
+
+		notice := fmt.Sprintf("[%d] %s  %s", job.Id, state, job.Line)
+		if job.err != nil {
+			notice = fmt.Sprintf("%s  (%s)", notice, job.err.Error())
+		}
+		Fprintln(streams.Stderr, notice)
+	}
+}
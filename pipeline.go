@@ -0,0 +1,230 @@
+package commander
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/hashibuto/nilshell/pkg/termutils"
+)
+
+const (
+	MAX_LINE_BUFFER = 64 * 1024
+)
+
+var (
+	ErrPipeClosed = errors.New("pipe closed")
+)
+
+// stdioLock serializes handlers whose output is captured by temporarily replacing os.Stdout and os.Stderr
+var stdioLock sync.Mutex
+
+// runPipeline executes each stage concurrently, connecting the output of each stage to the input of the next.  the
+// first stage reads from streams.Stdin and the final stage writes to streams.Stdout.  much like SIGPIPE in a unix
+// shell, when a stage exits, every stage upstream of it is cancelled and further writes to its input fail with
+// ErrPipeClosed.
+func runPipeline(ctx context.Context, stages []*BoundExec, streams Streams) error {
+	input := streams.Stdin
+	if input == nil {
+		input = bytes.NewReader(nil)
+	}
+
+	stderr := streams.Stderr
+	if len(stages) > 1 {
+		stderr = &syncWriter{target: stderr}
+	}
+
+	executions := make([]*Execution, len(stages))
+	writers := make([]io.WriteCloser, len(stages))
+	readers := make([]*io.PipeReader, len(stages))
+	cancels := make([]context.CancelCauseFunc, len(stages))
+	for i, stage := range stages {
+		stageCtx, cancel := context.WithCancelCause(ctx)
+		cancels[i] = cancel
+		executions[i] = &Execution{
+			Command: stage.Command,
+			Path:    stage.commandPath,
+			Args:    stage.ArgMap,
+			Sources: stage.Sources,
+			Stdin:   input,
+			Stdout:  streams.Stdout,
+			Stderr:  stderr,
+			ctx:     stageCtx,
+		}
+
+		if i < len(stages)-1 {
+			pipeRead, pipeWrite := io.Pipe()
+			// we don't pass terminal codes between stages
+			writers[i] = newEscapeStripper(pipeWrite)
+			readers[i+1] = pipeRead
+			executions[i].Stdout = writers[i]
+			input = pipeRead
+		}
+
+		if stage.stderr != nil {
+			executions[i].Stderr = stage.stderr
+		}
+
+		if stage.mergeStderr {
+			merged := &syncWriter{target: executions[i].Stdout}
+			executions[i].Stdout = merged
+			executions[i].Stderr = merged
+		}
+	}
+
+	errs := make([]error, len(stages))
+	wg := sync.WaitGroup{}
+	for i, stage := range stages {
+		wg.Add(1)
+		go func(i int, stage *BoundExec) {
+			defer wg.Done()
+
+			errs[i] = stage.Command.execute(executions[i])
+			if writers[i] != nil {
+				writers[i].Close()
+			}
+
+			if readers[i] != nil {
+				readers[i].CloseWithError(ErrPipeClosed)
+			}
+			for j := 0; j < i; j++ {
+				cancels[j](ErrPipeClosed)
+			}
+		}(i, stage)
+	}
+	wg.Wait()
+
+	for _, cancel := range cancels {
+		cancel(nil)
+	}
+
+	for _, err := range errs {
+		// an upstream stage which was cut off by its downstream stage has not failed
+		if err != nil && !errors.Is(err, ErrPipeClosed) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// captureStdio invokes fn with os.Stdout and os.Stderr temporarily replaced, such that everything written to them is
+// copied to stdout and stderr respectively.  a stream is left untouched if it is already the intended target.
+func captureStdio(stdout io.Writer, stderr io.Writer, fn func() error) error {
+	isStdout := stdout == io.Writer(os.Stdout)
+	isStderr := stderr == io.Writer(os.Stderr)
+	if isStdout && isStderr {
+		return fn()
+	}
+
+	stdioLock.Lock()
+	defer stdioLock.Unlock()
+
+	restoreFuncs := []func() error{}
+	if !isStdout {
+		restore, err := redirectFile(&os.Stdout, stdout)
+		if err != nil {
+			return err
+		}
+		restoreFuncs = append(restoreFuncs, restore)
+	}
+
+	if !isStderr {
+		restore, err := redirectFile(&os.Stderr, stderr)
+		if err != nil {
+			for _, restore := range restoreFuncs {
+				restore()
+			}
+			return err
+		}
+		restoreFuncs = append(restoreFuncs, restore)
+	}
+
+	err := fn()
+	for _, restore := range restoreFuncs {
+		restoreErr := restore()
+		if err == nil {
+			err = restoreErr
+		}
+	}
+
+	return err
+}
+
+// redirectFile replaces the file referenced by target with a pipe, copying everything written to it to output.  the
+// returned function restores the original file once everything written has been copied.
+func redirectFile(target **os.File, output io.Writer) (func() error, error) {
+	pipeRead, pipeWrite, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	formerFile := *target
+	*target = pipeWrite
+	completionChan := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(output, pipeRead)
+		if err != nil {
+			// continue draining, so that the handler never blocks on a full pipe
+			io.Copy(io.Discard, pipeRead)
+		}
+		pipeRead.Close()
+		completionChan <- err
+	}()
+
+	return func() error {
+		pipeWrite.Close()
+		err := <-completionChan
+		*target = formerFile
+		return err
+	}, nil
+}
+
+// escapeStripper removes terminal escape sequences from everything written to it, one line at a time, before passing
+// it on to the target
+type escapeStripper struct {
+	target io.WriteCloser
+	buffer []byte
+}
+
+func newEscapeStripper(target io.WriteCloser) *escapeStripper {
+	return &escapeStripper{
+		target: target,
+	}
+}
+
+func (s *escapeStripper) Write(p []byte) (int, error) {
+	s.buffer = append(s.buffer, p...)
+
+	end := bytes.LastIndexByte(s.buffer, '\n') + 1
+	if end == 0 {
+		if len(s.buffer) < MAX_LINE_BUFFER {
+			return len(p), nil
+		}
+		end = len(s.buffer)
+	}
+
+	_, err := s.target.Write(termutils.StripTerminalEscapeSequences(s.buffer[:end]))
+	s.buffer = append([]byte{}, s.buffer[end:]...)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (s *escapeStripper) Close() error {
+	if len(s.buffer) > 0 {
+		_, err := s.target.Write(termutils.StripTerminalEscapeSequences(s.buffer))
+		s.buffer = nil
+		if err != nil {
+			s.target.Close()
+			return err
+		}
+	}
+
+	return s.target.Close()
+}
@@ -0,0 +1,136 @@
+package commander
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	ns "github.com/hashibuto/nilshell"
+)
+
+const (
+	MAX_SCRIPT_DEPTH = 32
+)
+
+type scriptDepthKey struct{}
+
+// ScriptConfig controls how a script is executed by RunScript
+type ScriptConfig struct {
+	Name        string // name used to identify the script when reporting errors, typically the file path
+	StopOnError bool   // if enabled, execution stops at the first line which fails
+}
+
+// ScriptError reports the failure of a single script line
+type ScriptError struct {
+	Name string
+	Line int
+	Err  error
+}
+
+func (e *ScriptError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.Name, e.Line, e.Err.Error())
+}
+
+func (e *ScriptError) Unwrap() error {
+	return e.Err
+}
+
+// RunScript executes each line read from the reader as though it had been entered into the interactive shell.  Lines
+// beginning with # are treated as comments, blank lines are ignored, and a line ending with \ is continued on the
+// following line.  Failures are returned as ScriptErrors, and if StopOnError is not enabled, all failures are joined
+// into the returned error.  Invoking exit from within a script ends the script.
+func (c *Commander) RunScript(reader io.Reader, config ScriptConfig) error {
+	return c.RunScriptContext(context.Background(), reader, config)
+}
+
+// RunScriptContext is the same as RunScript, but stops executing once the context is cancelled
+func (c *Commander) RunScriptContext(ctx context.Context, reader io.Reader, config ScriptConfig) error {
+	return c.runScript(ctx, reader, config, Streams{
+		Stdout: c.streams.Stdout,
+		Stderr: c.streams.Stderr,
+	})
+}
+
+// runScript executes the script, with the output of each line written to the supplied streams
+func (c *Commander) runScript(ctx context.Context, reader io.Reader, config ScriptConfig, streams Streams) error {
+	if config.Name == "" {
+		config.Name = "<script>"
+	}
+
+	// the depth is carried by the context, such that scripts sourced concurrently (ie. as background jobs) are each
+	// limited by their own nesting alone
+	depth, _ := ctx.Value(scriptDepthKey{}).(int)
+	if depth >= MAX_SCRIPT_DEPTH {
+		return fmt.Errorf("maximum script depth of %d exceeded", MAX_SCRIPT_DEPTH)
+	}
+	ctx = context.WithValue(ctx, scriptDepthKey{}, depth+1)
+
+	scriptErrors := []error{}
+	scanner := bufio.NewScanner(reader)
+	lineNum := 0
+	startLine := 0
+	pending := []string{}
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+
+		lineNum++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(pending) == 0 {
+			startLine = lineNum
+			trimmed := strings.TrimSpace(line)
+			if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+				continue
+			}
+		}
+
+		if strings.HasSuffix(line, "\\") {
+			pending = append(pending, strings.TrimSuffix(line, "\\"))
+			continue
+		}
+
+		pending = append(pending, line)
+		input := strings.TrimSpace(strings.Join(pending, ""))
+		pending = []string{}
+
+		err := c.executeLine(ctx, input, streams)
+		if err == ns.ErrEof {
+			return errors.Join(scriptErrors...)
+		}
+
+		if err != nil {
+			scriptErr := &ScriptError{
+				Name: config.Name,
+				Line: startLine,
+				Err:  err,
+			}
+			// an interrupted script never continues, regardless of StopOnError
+			if config.StopOnError || errors.Is(err, ErrInterrupted) {
+				return scriptErr
+			}
+			scriptErrors = append(scriptErrors, scriptErr)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("unable to read %s: %w", config.Name, err)
+	}
+
+	if len(pending) > 0 {
+		scriptErr := &ScriptError{
+			Name: config.Name,
+			Line: startLine,
+			Err:  fmt.Errorf("unexpected end of script following line continuation"),
+		}
+		if config.StopOnError {
+			return scriptErr
+		}
+		scriptErrors = append(scriptErrors, scriptErr)
+	}
+
+	return errors.Join(scriptErrors...)
+}
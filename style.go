@@ -2,6 +2,7 @@ package commander
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"strings"
 )
@@ -17,12 +18,36 @@ func FgColor(red int, green int, blue int) string {
 	return fmt.Sprintf("\x1b[38;2;%d;%d;%dm", red, green, blue)
 }
 
+// Println writes the text to os.Stdout, followed by a style reset and a newline.  command handlers should write to the
+// execution instead (see Execution.Println).
 func Println(text ...string) {
-	fmt.Printf("%s%s\n", strings.Join(text, ""), C_RESET)
+	Fprintln(os.Stdout, text...)
 }
 
+// Errorln writes the text to os.Stderr in red, followed by a style reset and a newline.  command handlers should write
+// to the execution instead (see Execution.Errorln).
 func Errorln(text ...string) {
-	fmt.Fprintf(os.Stderr, "%s%s%s\n", C_RED, strings.Join(text, ""), C_RESET)
+	Ferrorln(os.Stderr, text...)
+}
+
+// Fprintln writes the text to w, followed by a style reset and a newline
+func Fprintln(w io.Writer, text ...string) {
+	fmt.Fprintf(w, "%s%s\n", strings.Join(text, ""), C_RESET)
+}
+
+// Ferrorln writes the text to w in red, followed by a style reset and a newline
+func Ferrorln(w io.Writer, text ...string) {
+	fmt.Fprintf(w, "%s%s%s\n", C_RED, strings.Join(text, ""), C_RESET)
+}
+
+// Println writes the text to the stdout of the execution
+func (e *Execution) Println(text ...string) {
+	Fprintln(e.Stdout, text...)
+}
+
+// Errorln writes the text to the stderr of the execution
+func (e *Execution) Errorln(text ...string) {
+	Ferrorln(e.Stderr, text...)
 }
 
 func Sprintf(text ...string) string {
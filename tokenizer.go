@@ -1,75 +1,511 @@
 package commander
 
-var (
-	FLOW_CONTROL_UNSPECIFIED byte = ' '
-	FLOW_CONTROL_PIPE        byte = '|'
-	FLOW_CONTROL_REDIRECT    byte = '>'
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
 )
 
-var ControlCharacters = map[byte]struct{}{
-	FLOW_CONTROL_PIPE:     {},
-	FLOW_CONTROL_REDIRECT: {},
+type FlowControl string
+
+const (
+	FLOW_CONTROL_UNSPECIFIED     FlowControl = ""
+	FLOW_CONTROL_PIPE            FlowControl = "|"
+	FLOW_CONTROL_PIPE_ALL        FlowControl = "|&" // pipes both stdout and stderr
+	FLOW_CONTROL_REDIRECT        FlowControl = ">"
+	FLOW_CONTROL_APPEND          FlowControl = ">>"
+	FLOW_CONTROL_INPUT           FlowControl = "<"
+	FLOW_CONTROL_REDIRECT_STDERR FlowControl = "2>"
+	FLOW_CONTROL_REDIRECT_ALL    FlowControl = "&>" // redirects both stdout and stderr
+	FLOW_CONTROL_SEQUENCE        FlowControl = ";"
+	FLOW_CONTROL_AND             FlowControl = "&&" // executes only if the previous pipeline succeeded
+	FLOW_CONTROL_OR              FlowControl = "||" // executes only if the previous pipeline failed
+	FLOW_CONTROL_BACKGROUND      FlowControl = "&"  // executes the preceding list in the background
+)
+
+// QUOTED_CHARS lists the characters which must be quoted or escaped in order to appear within a token
+const QUOTED_CHARS = " \t\n\"'\\$|&;<>()"
+
+// ControlOperators lists the operators recognized by the tokenizer, longest first such that the longest match wins
+var ControlOperators = []FlowControl{
+	FLOW_CONTROL_AND,
+	FLOW_CONTROL_OR,
+	FLOW_CONTROL_PIPE_ALL,
+	FLOW_CONTROL_REDIRECT_ALL,
+	FLOW_CONTROL_APPEND,
+	FLOW_CONTROL_PIPE,
+	FLOW_CONTROL_REDIRECT,
+	FLOW_CONTROL_INPUT,
+	FLOW_CONTROL_SEQUENCE,
+	FLOW_CONTROL_BACKGROUND,
 }
 
 type TokenGroup struct {
-	Tokens      []string
-	FlowControl byte
+	Tokens      []string // text of each token, with quotes and escapes removed and expansions applied
+	Words       []*Token // detail of each token, in the same order as Tokens
+	FlowControl FlowControl
+	Offset      int // byte offset within the line at which the group begins, including its flow control operator
 }
 
-func Tokenize(line string) []*TokenGroup {
-	allTokens := []*TokenGroup{}
-	tokenGroup := &TokenGroup{
-		Tokens:      []string{},
-		FlowControl: FLOW_CONTROL_UNSPECIFIED,
+// Token is a single word of a line of input
+type Token struct {
+	Text   string // text of the token, with quotes and escapes removed and expansions applied
+	Raw    string // text of the token as it appears in the line
+	Offset int    // byte offset within the line at which the token begins
+}
+
+// SyntaxError describes a line of input which could not be tokenized
+type SyntaxError struct {
+	Line    string
+	Offset  int // byte offset within the line at which the error was found
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at column %d: %s", e.Column(), e.Message)
+}
+
+// Column returns the 1 based position of the error within the line, counted in characters rather than bytes
+func (e *SyntaxError) Column() int {
+	return utf8.RuneCountInString(e.Line[:e.Offset]) + 1
+}
+
+// Indicator returns the line, followed by a second line which marks the position of the error
+func (e *SyntaxError) Indicator() string {
+	return fmt.Sprintf("%s\n%s^", e.Line, strings.Repeat(" ", e.Column()-1))
+}
+
+// Expander resolves the value of a parameter referenced during tokenization (ex. $?), returning false if the parameter
+// is not defined
+type Expander func(name string) (string, bool)
+
+// Substituter executes the command line of a command substitution (ex. $(get process)), returning its output
+type Substituter func(line string) (string, error)
+
+// IsRedirect returns true if the token group is the target of a redirection operator
+func (g *TokenGroup) IsRedirect() bool {
+	switch g.FlowControl {
+	case FLOW_CONTROL_REDIRECT, FLOW_CONTROL_APPEND, FLOW_CONTROL_INPUT, FLOW_CONTROL_REDIRECT_STDERR, FLOW_CONTROL_REDIRECT_ALL:
+		return true
 	}
-	curTok := []byte{}
+
+	return false
+}
+
+// IsListSeparator returns true if the token group begins a new pipeline within a command list
+func (g *TokenGroup) IsListSeparator() bool {
+	switch g.FlowControl {
+	case FLOW_CONTROL_SEQUENCE, FLOW_CONTROL_AND, FLOW_CONTROL_OR, FLOW_CONTROL_BACKGROUND:
+		return true
+	}
+
+	return false
+}
+
+// IsPipe returns true if the token group receives the output of the previous group through a pipe
+func (g *TokenGroup) IsPipe() bool {
+	return g.FlowControl == FLOW_CONTROL_PIPE || g.FlowControl == FLOW_CONTROL_PIPE_ALL
+}
+
+// IsListTerminator returns true if the token group begins a new list of pipelines joined by && and ||
+func (g *TokenGroup) IsListTerminator() bool {
+	return g.FlowControl == FLOW_CONTROL_SEQUENCE || g.FlowControl == FLOW_CONTROL_BACKGROUND
+}
+
+// matchOperator returns the control operator found at the start of text, if any
+func matchOperator(text string) (FlowControl, bool) {
+	for _, operator := range ControlOperators {
+		if strings.HasPrefix(text, string(operator)) {
+			return operator, true
+		}
+	}
+
+	return FLOW_CONTROL_UNSPECIFIED, false
+}
+
+// IsVariableName returns true if the name is a valid variable name, consisting of letters, digits and underscores,
+// and not beginning with a digit
+func IsVariableName(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+
+	for i := 0; i < len(name); i++ {
+		if !isNameChar(name[i]) || (i == 0 && name[i] >= '0' && name[i] <= '9') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isNameChar(char byte) bool {
+	return char == '_' || (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || (char >= '0' && char <= '9')
+}
+
+// parseParameter parses a parameter reference ($?, $NAME or ${NAME}) at the start of text, returning the name of the
+// parameter and the length of the reference, or a length of 0 if text does not begin with a parameter reference
+func parseParameter(text string) (string, int) {
+	if len(text) < 2 || text[0] != '$' {
+		return "", 0
+	}
+
+	if text[1] == '?' {
+		return "?", 2
+	}
+
+	if text[1] == '{' {
+		end := strings.IndexByte(text, '}')
+		if end == -1 || !IsVariableName(text[2:end]) {
+			return "", 0
+		}
+		return text[2:end], end + 1
+	}
+
+	length := 1
+	for length < len(text) && isNameChar(text[length]) {
+		length++
+	}
+
+	if !IsVariableName(text[1:length]) {
+		return "", 0
+	}
+
+	return text[1:length], length
+}
+
+// quoteToken returns the text as it would need to appear in a line in order to produce a single token of the same text.
+// the text is quoted in the same manner as the raw token, if it begins with a quote, otherwise any characters which
+// would be interpreted by the tokenizer are escaped.
+func quoteToken(text string, raw string) string {
+	switch {
+	case strings.HasPrefix(raw, "'") && !strings.Contains(text, "'"):
+		return "'" + text + "'"
+	case strings.HasPrefix(raw, "\""):
+		return "\"" + escape(text, "\"\\$`") + "\""
+	}
+
+	return escape(text, QUOTED_CHARS)
+}
+
+// escape prefixes each of the special characters within text with a backslash
+func escape(text string, special string) string {
+	escaped := strings.Builder{}
+	for _, r := range text {
+		if strings.ContainsRune(special, r) {
+			escaped.WriteByte('\\')
+		}
+		escaped.WriteRune(r)
+	}
+
+	return escaped.String()
+}
+
+// parseSubstitution parses a command substitution ($(...)) at the start of text, which may itself contain nested
+// substitutions, returning the command line within it and the length of the substitution.  if the substitution is
+// unterminated, the remainder of the text is consumed and false is returned.
+func parseSubstitution(text string) (string, int, bool) {
+	depth := 0
 	var quote byte = 0
-	in := false
-	for i := 0; i < len(line); i++ {
-		if quote == 0 {
-			if _, has := ControlCharacters[line[i]]; has {
-				allTokens = append(allTokens, tokenGroup)
-				tokenGroup = &TokenGroup{
-					Tokens:      []string{},
-					FlowControl: line[i],
-				}
-				continue
+	for i := 1; i < len(text); i++ {
+		switch {
+		case text[i] == '\\' && quote != '\'':
+			i++
+		case quote != 0:
+			if text[i] == quote {
+				quote = 0
+			}
+		case text[i] == '\'' || text[i] == '"':
+			quote = text[i]
+		case text[i] == '(':
+			depth++
+		case text[i] == ')':
+			depth--
+			if depth == 0 {
+				return text[2:i], i + 1, true
 			}
 		}
+	}
+
+	return "", len(text), false
+}
 
-		if !in && line[i] == ' ' || line[i] == '\t' {
+// Tokenize splits the line into groups of tokens separated by control operators, without expanding any parameters or
+// command substitutions.  if the line contains a syntax error, the groups are returned as far as they could be
+// determined, along with a *SyntaxError.
+func Tokenize(line string) ([]*TokenGroup, error) {
+	return TokenizeAndExpand(line, nil, nil)
+}
+
+// TokenizeAndExpand splits the line into groups of tokens separated by control operators.  parameter references
+// ($NAME or ${NAME}) outside of single quotes are replaced by the value supplied by expand, or by nothing if the
+// parameter is undefined.  the special parameter $? refers to the exit status of the previous command.  command
+// substitutions ($(...)) outside of single quotes are replaced by the output of substitute, which is split into
+// multiple tokens at whitespace unless it appears within double quotes.  a nil expand or substitute leaves the
+// respective references in place.
+//
+// text within single quotes is taken literally, while within double quotes a backslash escapes only ", \, $ and `.
+// outside of quotes, a backslash escapes any character.  adjacent quoted and unquoted text forms a single token.
+func TokenizeAndExpand(line string, expand Expander, substitute Substituter) ([]*TokenGroup, error) {
+	l := &lexer{
+		line:       line,
+		expand:     expand,
+		substitute: substitute,
+		group:      &TokenGroup{Tokens: []string{}, Words: []*Token{}},
+		start:      -1,
+	}
+
+	err := l.run()
+	if err != nil {
+		return nil, err
+	}
+
+	if l.err != nil {
+		return l.groups, l.err
+	}
+
+	return l.groups, nil
+}
+
+// lexer holds the state of a line being tokenized
+type lexer struct {
+	line       string
+	expand     Expander
+	substitute Substituter
+
+	groups []*TokenGroup
+	group  *TokenGroup
+	text   []byte // text of the current token
+	start  int    // offset at which the current token began, or -1 between tokens
+	pos    int
+	err    *SyntaxError // the first syntax error encountered
+}
+
+// run tokenizes the whole line, returning only those errors which prevent tokenization from continuing.  syntax
+// errors are recorded, and the remainder of the line is tokenized as well as possible.
+func (l *lexer) run() error {
+	for l.pos < len(l.line) {
+		r, size := utf8.DecodeRuneInString(l.line[l.pos:])
+		if operator, ok := matchOperator(l.line[l.pos:]); ok {
+			l.lexOperator(operator)
 			continue
 		}
 
-		if !in && line[i] == 39 || line[i] == '"' {
-			quote = line[i]
-			in = true
-			continue
+		var err error
+		switch r {
+		case ' ', '\t', '\n':
+			l.flush()
+			l.pos += size
+		case '\\':
+			l.lexEscape()
+		case '\'':
+			l.lexSingleQuoted()
+		case '"':
+			err = l.lexDoubleQuoted()
+		case '$':
+			err = l.lexDollar(false)
+		default:
+			l.begin(l.pos)
+			l.text = append(l.text, l.line[l.pos:l.pos+size]...)
+			l.pos += size
+		}
+		if err != nil {
+			return err
 		}
+	}
 
-		if quote != 0 && line[i] == quote {
-			quote = 0
-			continue
+	l.flush()
+
+	// a trailing operator is retained, even without tokens, such that it can be reported as incomplete
+	if len(l.group.Tokens) > 0 || l.group.FlowControl != FLOW_CONTROL_UNSPECIFIED {
+		l.groups = append(l.groups, l.group)
+	}
+
+	return nil
+}
+
+// fail records a syntax error, unless one has been recorded already
+func (l *lexer) fail(offset int, message string) {
+	if l.err == nil {
+		l.err = &SyntaxError{
+			Line:    l.line,
+			Offset:  offset,
+			Message: message,
 		}
+	}
+}
 
-		if in && quote == 0 && line[i] == ' ' || line[i] == '\t' {
-			in = false
-			tokenGroup.Tokens = append(tokenGroup.Tokens, string(curTok))
-			curTok = []byte{}
-			continue
+// begin starts a new token at offset, if one isn't already in progress
+func (l *lexer) begin(offset int) {
+	if l.start == -1 {
+		l.start = offset
+	}
+}
+
+// flush completes the current token, if one is in progress
+func (l *lexer) flush() {
+	if l.start == -1 {
+		return
+	}
+
+	l.group.Tokens = append(l.group.Tokens, string(l.text))
+	l.group.Words = append(l.group.Words, &Token{
+		Text:   string(l.text),
+		Raw:    l.line[l.start:l.pos],
+		Offset: l.start,
+	})
+	l.text = []byte{}
+	l.start = -1
+}
+
+func (l *lexer) lexOperator(operator FlowControl) {
+	offset := l.pos
+
+	// a 2 immediately preceding > refers to stderr, rather than being a token of its own
+	if operator == FLOW_CONTROL_REDIRECT && l.start != -1 && l.line[l.start:l.pos] == "2" {
+		operator = FLOW_CONTROL_REDIRECT_STDERR
+		offset = l.start
+		l.text = []byte{}
+		l.start = -1
+	}
+
+	l.flush()
+	l.pos = offset + len(operator)
+	l.groups = append(l.groups, l.group)
+	l.group = &TokenGroup{
+		Tokens:      []string{},
+		Words:       []*Token{},
+		FlowControl: operator,
+		Offset:      offset,
+	}
+}
+
+func (l *lexer) lexEscape() {
+	l.begin(l.pos)
+	if l.pos+1 >= len(l.line) {
+		l.fail(l.pos, "unterminated escape")
+		l.pos++
+		return
+	}
+
+	_, size := utf8.DecodeRuneInString(l.line[l.pos+1:])
+	l.text = append(l.text, l.line[l.pos+1:l.pos+1+size]...)
+	l.pos += 1 + size
+}
+
+func (l *lexer) lexSingleQuoted() {
+	l.begin(l.pos)
+	end := strings.IndexByte(l.line[l.pos+1:], '\'')
+	if end == -1 {
+		l.fail(l.pos, "unterminated quote")
+		l.text = append(l.text, l.line[l.pos+1:]...)
+		l.pos = len(l.line)
+		return
+	}
+
+	l.text = append(l.text, l.line[l.pos+1:l.pos+1+end]...)
+	l.pos += end + 2
+}
+
+func (l *lexer) lexDoubleQuoted() error {
+	l.begin(l.pos)
+	quoteStart := l.pos
+	l.pos++
+	for l.pos < len(l.line) {
+		switch l.line[l.pos] {
+		case '"':
+			l.pos++
+			return nil
+		case '\\':
+			if l.pos+1 < len(l.line) && strings.IndexByte("\"\\$`", l.line[l.pos+1]) != -1 {
+				l.text = append(l.text, l.line[l.pos+1])
+				l.pos += 2
+				continue
+			}
+			l.text = append(l.text, '\\')
+			l.pos++
+		case '$':
+			err := l.lexDollar(true)
+			if err != nil {
+				return err
+			}
+		default:
+			l.text = append(l.text, l.line[l.pos])
+			l.pos++
+		}
+	}
+
+	l.fail(quoteStart, "unterminated quote")
+	return nil
+}
+
+// lexDollar handles a $, which may begin a parameter reference or command substitution
+func (l *lexer) lexDollar(quoted bool) error {
+	if strings.HasPrefix(l.line[l.pos:], "$(") {
+		return l.lexSubstitution(quoted)
+	}
+
+	if l.expand != nil {
+		if name, length := parseParameter(l.line[l.pos:]); length > 0 {
+			// an unquoted reference which expands to nothing does not produce a token
+			value, _ := l.expand(name)
+			if len(value) > 0 {
+				l.begin(l.pos)
+			}
+			l.text = append(l.text, value...)
+			l.pos += length
+			return nil
 		}
+	}
 
-		in = true
-		curTok = append(curTok, line[i])
+	l.begin(l.pos)
+	l.text = append(l.text, '$')
+	l.pos++
+	return nil
+}
+
+func (l *lexer) lexSubstitution(quoted bool) error {
+	offset := l.pos
+	command, length, ok := parseSubstitution(l.line[l.pos:])
+	if !ok {
+		l.fail(offset, "unterminated command substitution")
+	}
+
+	if l.substitute == nil || !ok {
+		// the substitution is retained verbatim, such that any operators within it aren't interpreted
+		l.begin(offset)
+		l.text = append(l.text, l.line[offset:offset+length]...)
+		l.pos += length
+		return nil
+	}
+
+	output, err := l.substitute(command)
+	if err != nil {
+		return err
 	}
+	output = strings.TrimRight(output, "\n")
 
-	if len(curTok) > 0 {
-		tokenGroup.Tokens = append(tokenGroup.Tokens, string(curTok))
+	if quoted {
+		l.text = append(l.text, output...)
+		l.pos += length
+		return nil
 	}
 
-	if len(tokenGroup.Tokens) > 0 {
-		allTokens = append(allTokens, tokenGroup)
+	// an unquoted substitution is split into words, the first and last of which are joined to any adjacent text
+	if strings.TrimLeft(output, " \t\n") != output {
+		l.flush()
+	}
+	l.pos += length
+	for i, word := range strings.Fields(output) {
+		if i > 0 {
+			l.flush()
+		}
+		l.begin(offset)
+		l.text = append(l.text, word...)
+	}
+	if strings.TrimRight(output, " \t\n") != output {
+		l.flush()
 	}
 
-	return allTokens
+	return nil
 }
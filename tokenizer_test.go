@@ -1,6 +1,7 @@
 package commander
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -8,7 +9,8 @@ import (
 
 func TestTokenizer_LeadingTrailing(t *testing.T) {
 	line := "  hello   there  macaroni    "
-	tokenGroups := Tokenize(line)
+	tokenGroups, err := Tokenize(line)
+	assert.NoError(t, err)
 	assert.Len(t, tokenGroups, 1)
 	tokens := tokenGroups[0].Tokens
 
@@ -17,3 +19,134 @@ func TestTokenizer_LeadingTrailing(t *testing.T) {
 	assert.Equal(t, tokens[1], "there")
 	assert.Equal(t, tokens[2], "macaroni")
 }
+
+func TestTokenizer_RedirectOperators(t *testing.T) {
+	line := "grep db < in.txt 2>err.txt|& grep x >> out.txt"
+	tokenGroups, err := Tokenize(line)
+	assert.NoError(t, err)
+	assert.Len(t, tokenGroups, 5)
+
+	assert.Equal(t, []string{"grep", "db"}, tokenGroups[0].Tokens)
+	assert.Equal(t, FLOW_CONTROL_INPUT, tokenGroups[1].FlowControl)
+	assert.Equal(t, []string{"in.txt"}, tokenGroups[1].Tokens)
+	assert.Equal(t, FLOW_CONTROL_REDIRECT_STDERR, tokenGroups[2].FlowControl)
+	assert.Equal(t, []string{"err.txt"}, tokenGroups[2].Tokens)
+	assert.Equal(t, FLOW_CONTROL_PIPE_ALL, tokenGroups[3].FlowControl)
+	assert.Equal(t, []string{"grep", "x"}, tokenGroups[3].Tokens)
+	assert.Equal(t, FLOW_CONTROL_APPEND, tokenGroups[4].FlowControl)
+	assert.Equal(t, []string{"out.txt"}, tokenGroups[4].Tokens)
+}
+
+func TestTokenizer_TrailingOperator(t *testing.T) {
+	tokenGroups, err := Tokenize("hello >")
+	assert.NoError(t, err)
+	assert.Len(t, tokenGroups, 2)
+	assert.Equal(t, FLOW_CONTROL_REDIRECT, tokenGroups[1].FlowControl)
+	assert.Len(t, tokenGroups[1].Tokens, 0)
+}
+
+func TestTokenizer_ListOperators(t *testing.T) {
+	tokenGroups, err := Tokenize("a && b || c; d | e & f &> g")
+	assert.NoError(t, err)
+	assert.Len(t, tokenGroups, 7)
+
+	flowControls := []FlowControl{}
+	for _, tokenGroup := range tokenGroups {
+		flowControls = append(flowControls, tokenGroup.FlowControl)
+	}
+	assert.Equal(t, []FlowControl{
+		FLOW_CONTROL_UNSPECIFIED,
+		FLOW_CONTROL_AND,
+		FLOW_CONTROL_OR,
+		FLOW_CONTROL_SEQUENCE,
+		FLOW_CONTROL_PIPE,
+		FLOW_CONTROL_BACKGROUND,
+		FLOW_CONTROL_REDIRECT_ALL,
+	}, flowControls)
+}
+
+func TestTokenizer_Expansion(t *testing.T) {
+	variables := map[string]string{
+		"target": "db01",
+	}
+	expand := func(name string) (string, bool) {
+		value, ok := variables[name]
+		return value, ok
+	}
+
+	tokenGroups, err := TokenizeAndExpand(`restart $target "${target}-b" '$target' $ $1 $missing`, expand, nil)
+	assert.NoError(t, err)
+	assert.Len(t, tokenGroups, 1)
+	assert.Equal(t, []string{"restart", "db01", "db01-b", "$target", "$", "$1"}, tokenGroups[0].Tokens)
+}
+
+func TestTokenizer_Substitution(t *testing.T) {
+	substitute := func(line string) (string, error) {
+		switch line {
+		case "list | grep db":
+			return "db01\ndb02\n", nil
+		case "fail":
+			return "", fmt.Errorf("failed")
+		}
+		return line, nil
+	}
+
+	// without a substituter, the substitution is retained as it is, including operators
+	tokenGroups, err := Tokenize(`restart $(get $(list) | grep "a)") && done`)
+	assert.NoError(t, err)
+	assert.Len(t, tokenGroups, 2)
+	assert.Equal(t, []string{"restart", `$(get $(list) | grep "a)")`}, tokenGroups[0].Tokens)
+
+	tokenGroups, err = TokenizeAndExpand(`restart $(list | grep db) "$(a  b)" x$(c d)y '$(e)' $(   )`, nil, substitute)
+	assert.NoError(t, err)
+	assert.Len(t, tokenGroups, 1)
+	assert.Equal(t, []string{"restart", "db01", "db02", "a  b", "xc", "dy", "$(e)"}, tokenGroups[0].Tokens)
+
+	_, err = TokenizeAndExpand("restart $(fail)", nil, substitute)
+	assert.EqualError(t, err, "failed")
+
+	_, err = TokenizeAndExpand("restart $(list", nil, substitute)
+	assert.EqualError(t, err, "syntax error at column 9: unterminated command substitution")
+}
+
+func TestTokenizer_QuotesAndEscapes(t *testing.T) {
+	tokenGroups, err := Tokenize(`set --name="a b"c 'it''s' \"x\ y\" "say \"hi\" \n" \|\& naïve`)
+	assert.NoError(t, err)
+	assert.Len(t, tokenGroups, 1)
+	assert.Equal(t, []string{"set", "--name=a bc", "its", `"x y"`, `say "hi" \n`, "|&", "naïve"}, tokenGroups[0].Tokens)
+
+	words := tokenGroups[0].Words
+	assert.Equal(t, `--name="a b"c`, words[1].Raw)
+	assert.Equal(t, 4, words[1].Offset)
+	assert.Equal(t, `\|\&`, words[5].Raw)
+}
+
+func TestTokenizer_SyntaxErrors(t *testing.T) {
+	cases := []struct {
+		line    string
+		message string
+		column  int
+	}{
+		{`say "héllo`, "unterminated quote", 5},
+		{`say 'ü''x`, "unterminated quote", 8},
+		{`say x\`, "unterminated escape", 6},
+		{`say $(get "a)"`, "unterminated command substitution", 5},
+	}
+
+	for _, c := range cases {
+		tokenGroups, err := Tokenize(c.line)
+		var syntaxErr *SyntaxError
+		if assert.ErrorAs(t, err, &syntaxErr, c.line) {
+			assert.Equal(t, c.message, syntaxErr.Message)
+			assert.Equal(t, c.column, syntaxErr.Column())
+		}
+
+		// the groups are still returned, so that an incomplete line can be completed
+		assert.Len(t, tokenGroups, 1)
+		assert.Len(t, tokenGroups[0].Tokens, 2)
+	}
+
+	_, err := Tokenize(`say "héllo`)
+	assert.EqualError(t, err, "syntax error at column 5: unterminated quote")
+	assert.Equal(t, "say \"héllo\n    ^", err.(*SyntaxError).Indicator())
+}
@@ -0,0 +1,217 @@
+package commander
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	ns "github.com/hashibuto/nilshell"
+	"github.com/hashibuto/nilshell/pkg/termutils"
+)
+
+// TranscriptEntry records the execution of a single line of input, and is written to the DumpFile as a line of JSON
+type TranscriptEntry struct {
+	Input    string    `json:"input"`
+	Time     time.Time `json:"time"`
+	Commands []string  `json:"commands,omitempty"` // path of each command the line resolved to, ex. "get process"
+	Status   int       `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	Output   *string   `json:"output,omitempty"` // combined stdout and stderr, only recorded if Config.DumpOutput is enabled
+}
+
+// Divergence describes an entry of a transcript whose replay did not match the transcript
+type Divergence struct {
+	Entry    int // 1 based position of the entry within the transcript
+	Expected *TranscriptEntry
+	Actual   *TranscriptEntry
+}
+
+// ReplayError is returned by Replay when one or more entries of the transcript diverged
+type ReplayError struct {
+	Entries     int // number of entries replayed
+	Divergences []*Divergence
+}
+
+type transcriptKey struct{}
+
+// lineRecord collects the commands resolved while executing a line which is being recorded
+type lineRecord struct {
+	lock     sync.Mutex
+	commands []string
+}
+
+func (e *ReplayError) Error() string {
+	lines := []string{fmt.Sprintf("%d of %d transcript entries diverged", len(e.Divergences), e.Entries)}
+	for _, divergence := range e.Divergences {
+		lines = append(lines, divergence.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// String describes the first difference between the expected and actual results of the entry
+func (d *Divergence) String() string {
+	prefix := fmt.Sprintf("entry %d (%s):", d.Entry, d.Expected.Input)
+	if d.Actual.Status != d.Expected.Status {
+		return fmt.Sprintf("%s exit status %d, expected %d", prefix, d.Actual.Status, d.Expected.Status)
+	}
+
+	if d.Actual.Error != d.Expected.Error {
+		return fmt.Sprintf("%s error %q, expected %q", prefix, d.Actual.Error, d.Expected.Error)
+	}
+
+	actual := strings.Split(*d.Actual.Output, "\n")
+	expected := strings.Split(*d.Expected.Output, "\n")
+	for i := 0; i < len(actual) || i < len(expected); i++ {
+		actualLine, expectedLine := "<end of output>", "<end of output>"
+		if i < len(actual) {
+			actualLine = fmt.Sprintf("%q", actual[i])
+		}
+		if i < len(expected) {
+			expectedLine = fmt.Sprintf("%q", expected[i])
+		}
+		if actualLine != expectedLine {
+			return fmt.Sprintf("%s output line %d is %s, expected %s", prefix, i+1, actualLine, expectedLine)
+		}
+	}
+
+	return fmt.Sprintf("%s output differs", prefix)
+}
+
+// transcriptStatus returns the exit status and error message to record for the error returned by executing a line
+func transcriptStatus(err error) (int, string) {
+	if err == nil || err == ns.ErrEof {
+		return EXIT_SUCCESS, ""
+	}
+
+	return ExitCode(err), err.Error()
+}
+
+// recordCommand notes the path of a command resolved while executing a line, if the line is being recorded
+func recordCommand(ctx context.Context, commandPath string) {
+	record, ok := ctx.Value(transcriptKey{}).(*lineRecord)
+	if !ok {
+		return
+	}
+
+	record.lock.Lock()
+	defer record.lock.Unlock()
+	record.commands = append(record.commands, commandPath)
+}
+
+// recordLine executes a line of input by way of execute, appending an entry describing it to the DumpFile.  if no
+// DumpFile is configured, the line is simply executed.
+func (c *Commander) recordLine(ctx context.Context, input string, streams Streams, execute func(ctx context.Context, streams Streams) error) error {
+	if c.Config.DumpFile == "" {
+		return execute(ctx, streams)
+	}
+
+	entry := &TranscriptEntry{
+		Input: input,
+		Time:  time.Now(),
+	}
+	record := &lineRecord{}
+	ctx = context.WithValue(ctx, transcriptKey{}, record)
+
+	output := &bytes.Buffer{}
+	if c.Config.DumpOutput {
+		// stdout and stderr may be written concurrently by the stages of a pipeline
+		writer := &syncWriter{target: output}
+		streams.Stdout = io.MultiWriter(streams.Stdout, writer)
+		streams.Stderr = io.MultiWriter(streams.Stderr, writer)
+	}
+
+	err := execute(ctx, streams)
+
+	entry.Commands = record.commands
+	entry.Status, entry.Error = transcriptStatus(err)
+	if c.Config.DumpOutput {
+		text := string(termutils.StripTerminalEscapeSequences(output.Bytes()))
+		entry.Output = &text
+	}
+
+	// failing to record the line doesn't change the outcome of executing it
+	c.writeTranscriptEntry(entry)
+	return err
+}
+
+// writeTranscriptEntry appends the entry to the DumpFile
+func (c *Commander) writeTranscriptEntry(entry *TranscriptEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	c.transcriptLock.Lock()
+	defer c.transcriptLock.Unlock()
+
+	f, err := os.OpenFile(c.Config.DumpFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// Replay executes each line of input recorded in the transcript, in order, and compares the exit status, error and
+// output (if it was recorded) with those of the transcript.  Execution continues past any entry which diverges, and a
+// *ReplayError describing every divergence is returned.
+func (c *Commander) Replay(transcript io.Reader) error {
+	replayErr := &ReplayError{}
+	scanner := bufio.NewScanner(transcript)
+	scanner.Buffer(make([]byte, 0, MAX_LINE_BUFFER), 64*MAX_LINE_BUFFER)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		expected := &TranscriptEntry{}
+		err := json.Unmarshal(scanner.Bytes(), expected)
+		if err != nil {
+			return fmt.Errorf("unable to read transcript entry %d: %w", replayErr.Entries+1, err)
+		}
+		replayErr.Entries++
+
+		output := &bytes.Buffer{}
+		writer := &syncWriter{target: output}
+		streams := Streams{Stdout: writer, Stderr: writer}
+		err = c.executeLine(context.Background(), expected.Input, streams)
+		c.reportJobs(streams)
+
+		actual := &TranscriptEntry{
+			Input: expected.Input,
+		}
+		actual.Status, actual.Error = transcriptStatus(err)
+		text := string(termutils.StripTerminalEscapeSequences(output.Bytes()))
+		actual.Output = &text
+
+		diverged := actual.Status != expected.Status || actual.Error != expected.Error
+		diverged = diverged || (expected.Output != nil && *actual.Output != *expected.Output)
+		if diverged {
+			replayErr.Divergences = append(replayErr.Divergences, &Divergence{
+				Entry:    replayErr.Entries,
+				Expected: expected,
+				Actual:   actual,
+			})
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if len(replayErr.Divergences) > 0 {
+		return replayErr
+	}
+
+	return nil
+}
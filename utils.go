@@ -2,42 +2,144 @@ package commander
 
 import (
 	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// GetValueFromString parses the value according to the registered definition of the ArgType, and validates the parsed
+// value if the type defines a Validate function
 func GetValueFromString(argType ArgType, value string) (any, error) {
-	switch argType {
-	case ArgTypeInt:
-		iVal, err := strconv.Atoi(value)
+	definition, ok := LookupArgType(argType)
+	if !ok {
+		return nil, fmt.Errorf("unknown arg type")
+	}
+
+	parsedValue, err := definition.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if definition.Validate != nil {
+		err = definition.Validate(parsedValue)
 		if err != nil {
-			return nil, fmt.Errorf("value could not be parsed to an integer")
+			return nil, err
 		}
+	}
+
+	return parsedValue, nil
+}
+
+// FormatValue formats a value according to the registered definition of its ArgType, or with fmt.Sprint if the type
+// defines no Format function
+func FormatValue(value any) string {
+	definition, ok := LookupArgType(InferArgType(value))
+	if ok && definition.Format != nil && reflect.TypeOf(value) == definition.ValueType {
+		return definition.Format(value)
+	}
+
+	return fmt.Sprint(value)
+}
+
+func parseInt(value string) (int, error) {
+	iVal, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("value could not be parsed to an integer")
+	}
+
+	return iVal, nil
+}
+
+func parseFloat(value string) (float64, error) {
+	fVal, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("value could not be parsed to a float")
+	}
+	return fVal, nil
+}
+
+func parseBool(value string) (bool, error) {
+	if value == "true" || value == "t" || value == "1" {
+		return true, nil
+	}
+	if value == "false" || value == "f" || value == "0" {
+		return false, nil
+	}
+	return false, fmt.Errorf("value could not be parsed into a bool")
+}
+
+func parseDuration(value string) (time.Duration, error) {
+	dVal, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("value could not be parsed to a duration")
+	}
+	return dVal, nil
+}
 
-		return iVal, nil
-	case ArgTypeFloat:
-		fVal, err := strconv.ParseFloat(value, 64)
+func parseIP(value string) (net.IP, error) {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("value could not be parsed to an IP address")
+	}
+	return ip, nil
+}
+
+func parseCIDR(value string) (*net.IPNet, error) {
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, fmt.Errorf("value could not be parsed to a CIDR network")
+	}
+	return network, nil
+}
+
+func parseURL(value string) (*url.URL, error) {
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme == "" {
+		return nil, fmt.Errorf("value could not be parsed to an absolute URL")
+	}
+	return u, nil
+}
+
+func parseRegexp(value string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(value)
+	if err != nil {
+		return nil, fmt.Errorf("value could not be parsed to a regular expression: %w", err)
+	}
+	return re, nil
+}
+
+// parseTime parses a time in RFC3339 form, a date (2006-01-02), "now", or a duration relative to now, which must be
+// signed (ex. -2h for two hours ago)
+func parseTime(value string) (time.Time, error) {
+	if value == "now" {
+		return time.Now(), nil
+	}
+
+	if strings.HasPrefix(value, "-") || strings.HasPrefix(value, "+") {
+		offset, err := time.ParseDuration(value)
 		if err != nil {
-			return nil, fmt.Errorf("value could not be parsed to a float")
-		}
-		return fVal, nil
-	case ArgTypeBool:
-		if value == "true" || value == "t" || value == "1" {
-			return true, nil
+			return time.Time{}, fmt.Errorf("value could not be parsed to a relative time")
 		}
-		if value == "false" || value == "f" || value == "0" {
-			return false, nil
+		return time.Now().Add(offset), nil
+	}
+
+	for _, layout := range []string{time.RFC3339Nano, time.DateOnly} {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t, nil
 		}
-		return nil, fmt.Errorf("value could not be parsed into a bool")
-	case ArgTypeString:
-		return value, nil
 	}
 
-	return nil, fmt.Errorf("unknown arg type")
+	return time.Time{}, fmt.Errorf("value could not be parsed to a time")
 }
 
 func MatchesOneOf(oneOf []any, sample any) bool {
 	for _, one := range oneOf {
-		if fmt.Sprintf("%s", one) == fmt.Sprintf("%s", sample) {
+		if FormatValue(one) == FormatValue(sample) {
 			return true
 		}
 	}
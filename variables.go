@@ -0,0 +1,119 @@
+package commander
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	ns "github.com/hashibuto/nilshell"
+)
+
+// SetVariable assigns a session variable, which may subsequently be referenced as $NAME or ${NAME}
+func (c *Commander) SetVariable(name string, value string) error {
+	if !IsVariableName(name) {
+		return fmt.Errorf("invalid variable name \"%s\"", name)
+	}
+
+	c.variableLock.Lock()
+	defer c.variableLock.Unlock()
+	c.variables[name] = value
+
+	return nil
+}
+
+// UnsetVariable removes a session variable
+func (c *Commander) UnsetVariable(name string) {
+	c.variableLock.Lock()
+	defer c.variableLock.Unlock()
+	delete(c.variables, name)
+}
+
+// GetVariable returns the value of a session variable.  if the variable isn't defined in the session and ExpandEnv is
+// enabled, the value is looked up in the environment.
+func (c *Commander) GetVariable(name string) (string, bool) {
+	c.variableLock.RLock()
+	value, ok := c.variables[name]
+	c.variableLock.RUnlock()
+	if ok {
+		return value, true
+	}
+
+	if c.Config.ExpandEnv {
+		return os.LookupEnv(name)
+	}
+
+	return "", false
+}
+
+// Variables returns a copy of all session variables
+func (c *Commander) Variables() map[string]string {
+	c.variableLock.RLock()
+	defer c.variableLock.RUnlock()
+
+	variables := map[string]string{}
+	for name, value := range c.variables {
+		variables[name] = value
+	}
+
+	return variables
+}
+
+// expand resolves the parameters which may be referenced in a line of input
+func (c *Commander) expand(name string) (string, bool) {
+	if name == "?" {
+		return strconv.Itoa(c.LastStatus()), true
+	}
+
+	return c.GetVariable(name)
+}
+
+// suggestVariables returns suggestions for the variable reference being typed at the end of token, if any
+func (c *Commander) suggestVariables(token string) *ns.Suggestions {
+	idx := strings.LastIndexByte(token, '$')
+	if idx == -1 {
+		return nil
+	}
+
+	prefix := token[idx+1:]
+	isBraced := strings.HasPrefix(prefix, "{")
+	if isBraced {
+		prefix = prefix[1:]
+	}
+
+	if prefix != "" && !IsVariableName(prefix) {
+		return nil
+	}
+
+	names := map[string]struct{}{}
+	for name := range c.Variables() {
+		names[name] = struct{}{}
+	}
+
+	if c.Config.ExpandEnv {
+		for _, env := range os.Environ() {
+			name, _, _ := strings.Cut(env, "=")
+			names[name] = struct{}{}
+		}
+	}
+
+	sortedNames := []string{}
+	for name := range names {
+		if strings.HasPrefix(name, prefix) {
+			sortedNames = append(sortedNames, name)
+		}
+	}
+	sort.Strings(sortedNames)
+
+	suggestions := ns.NewSuggestions()
+	for _, name := range sortedNames {
+		reference := "$" + name
+		if isBraced {
+			reference = "${" + name + "}"
+		}
+		suggestions.Add(ns.NewSuggestion(reference, token[:idx]+reference))
+	}
+
+	return suggestions
+}